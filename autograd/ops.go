@@ -0,0 +1,153 @@
+package autograd
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+)
+
+func checkSame(op string, a, b *Var) {
+	if a.Value.Rows != b.Value.Rows || a.Value.Cols != b.Value.Cols {
+		panic(fmt.Sprintf("autograd: %s of %dx%d and %dx%d", op, a.Value.Rows, a.Value.Cols, b.Value.Rows, b.Value.Cols))
+	}
+}
+
+func Add(a, b *Var) *Var {
+	checkSame("Add", a, b)
+	return record(a.Value.Add(b.Value), func(out *Var) {
+		a.grad().AddScaled(1, out.Grad)
+		b.grad().AddScaled(1, out.Grad)
+	}, a, b)
+}
+
+func Sub(a, b *Var) *Var {
+	checkSame("Sub", a, b)
+	return record(a.Value.Sub(b.Value), func(out *Var) {
+		a.grad().AddScaled(1, out.Grad)
+		b.grad().AddScaled(-1, out.Grad)
+	}, a, b)
+}
+
+// Mul multiplies a and b elementwise.
+func Mul(a, b *Var) *Var {
+	checkSame("Mul", a, b)
+	return record(a.Value.MultElems(b.Value), func(out *Var) {
+		ga, gb := a.grad(), b.grad()
+		for i, g := range out.Grad.X {
+			ga.X[i] += g * b.Value.X[i]
+			gb.X[i] += g * a.Value.X[i]
+		}
+	}, a, b)
+}
+
+// MatMul is the matrix product a * b.
+func MatMul(a, b *Var) *Var {
+	return record(a.Value.Multiply(b.Value), func(out *Var) {
+		a.grad().AddScaled(1, out.Grad.Multiply(b.Value.Transpose()))
+		b.grad().AddScaled(1, a.Value.Transpose().Multiply(out.Grad))
+	}, a, b)
+}
+
+// AddCol adds the column vector v to every column of a, e.g. a bias to a
+// batch.
+func AddCol(a, v *Var) *Var {
+	return record(a.Value.AddCol(v.Value), func(out *Var) {
+		a.grad().AddScaled(1, out.Grad)
+		v.grad().AddScaled(1, out.Grad.SumCols())
+	}, a, v)
+}
+
+// MulCol multiplies every column of a elementwise by the column vector v.
+func MulCol(a, v *Var) *Var {
+	if v.Value.Cols != 1 || v.Value.Rows != a.Value.Rows {
+		panic(fmt.Sprintf("autograd: MulCol of %dx%d and %dx%d", a.Value.Rows, a.Value.Cols, v.Value.Rows, v.Value.Cols))
+	}
+	cols := a.Value.Cols
+	value := lab.NewMatrix(a.Value.Rows, cols)
+	for i, x := range a.Value.X {
+		value.X[i] = x * v.Value.X[i/cols]
+	}
+	return record(value, func(out *Var) {
+		ga, gv := a.grad(), v.grad()
+		for i, g := range out.Grad.X {
+			ga.X[i] += g * v.Value.X[i/cols]
+			gv.X[i/cols] += g * a.Value.X[i]
+		}
+	}, a, v)
+}
+
+func Scale(a *Var, s float64) *Var {
+	return record(a.Value.Scale(s), func(out *Var) {
+		a.grad().AddScaled(s, out.Grad)
+	}, a)
+}
+
+func Transpose(a *Var) *Var {
+	return record(a.Value.Transpose(), func(out *Var) {
+		a.grad().AddScaled(1, out.Grad.Transpose())
+	}, a)
+}
+
+// Sum adds up every element of a into a 1x1 result.
+func Sum(a *Var) *Var {
+	var sum float64
+	for _, x := range a.Value.X {
+		sum += x
+	}
+	return record(lab.Solid(1, 1, sum), func(out *Var) {
+		g := a.grad()
+		for i := range g.X {
+			g.X[i] += out.Grad.X[0]
+		}
+	}, a)
+}
+
+// Mean averages every element of a into a 1x1 result.
+func Mean(a *Var) *Var {
+	return Scale(Sum(a), 1/float64(len(a.Value.X)))
+}
+
+// unary records f applied elementwise to a, where df gives the derivative
+// from the input x and output y.
+func unary(a *Var, f func(x float64) float64, df func(x, y float64) float64) *Var {
+	value := lab.NewMatrix(a.Value.Rows, a.Value.Cols)
+	for i, x := range a.Value.X {
+		value.X[i] = f(x)
+	}
+	return record(value, func(out *Var) {
+		g := a.grad()
+		for i, x := range a.Value.X {
+			g.X[i] += out.Grad.X[i] * df(x, out.Value.X[i])
+		}
+	}, a)
+}
+
+func Exp(a *Var) *Var {
+	return unary(a, math.Exp, func(_, y float64) float64 { return y })
+}
+
+func Log(a *Var) *Var {
+	return unary(a, math.Log, func(x, _ float64) float64 { return 1 / x })
+}
+
+func Square(a *Var) *Var {
+	return unary(a, func(x float64) float64 { return x * x }, func(x, _ float64) float64 { return 2 * x })
+}
+
+func Tanh(a *Var) *Var {
+	return unary(a, math.Tanh, func(_, y float64) float64 { return 1 - y*y })
+}
+
+func Sigmoid(a *Var) *Var {
+	return unary(a, func(x float64) float64 { return 1 / (1 + math.Exp(-x)) },
+		func(_, y float64) float64 { return y * (1 - y) })
+}
+
+func ReLU(a *Var) *Var {
+	return unary(a, func(x float64) float64 { return math.Max(x, 0) }, func(x, _ float64) float64 {
+		if x > 0 {
+			return 1
+		}
+		return 0
+	})
+}
@@ -0,0 +1,93 @@
+// Package autograd computes gradients of matrix expressions by reverse-mode
+// differentiation. Operations on Vars are recorded on a Tape as they run;
+// Tape.Backward then replays them in reverse to accumulate the gradient of
+// the result with respect to every Var.
+package autograd
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+)
+
+// Var is a matrix value on a tape. Grad holds the gradient accumulated by
+// Tape.Backward and is nil until then.
+type Var struct {
+	Value *lab.Matrix
+	Grad  *lab.Matrix
+
+	tape *Tape
+}
+
+// Tape records the operations on its Vars in the order they ran.
+type Tape struct {
+	nodes []node
+}
+
+type node struct {
+	out  *Var
+	back func(out *Var)
+}
+
+func NewTape() *Tape {
+	return &Tape{}
+}
+
+// Var adds m to the tape as an input. m is not copied.
+func (t *Tape) Var(m *lab.Matrix) *Var {
+	return &Var{Value: m, tape: t}
+}
+
+// Reset forgets the recorded operations so the tape can be reused.
+func (t *Tape) Reset() {
+	t.nodes = t.nodes[:0]
+}
+
+// Backward propagates the gradient of out, which must be 1x1, to every Var
+// that out was computed from.
+func (t *Tape) Backward(out *Var) {
+	if out.Value.Rows != 1 || out.Value.Cols != 1 {
+		panic(fmt.Sprintf("autograd: Backward needs a scalar, got %dx%d; use BackwardWith", out.Value.Rows, out.Value.Cols))
+	}
+	t.BackwardWith(out, lab.Solid(1, 1, 1))
+}
+
+// BackwardWith propagates grad, the gradient with respect to out, to every
+// Var that out was computed from. The gradients of inputs added with Var add
+// up over repeated calls; those of intermediate results are recomputed.
+func (t *Tape) BackwardWith(out *Var, grad *lab.Matrix) {
+	if grad.Rows != out.Value.Rows || grad.Cols != out.Value.Cols {
+		panic(fmt.Sprintf("autograd: gradient is %dx%d, value is %dx%d", grad.Rows, grad.Cols, out.Value.Rows, out.Value.Cols))
+	}
+	for _, n := range t.nodes {
+		n.out.Grad = nil
+	}
+	out.grad().AddScaled(1, grad)
+	for i := len(t.nodes) - 1; i >= 0; i-- {
+		if n := t.nodes[i]; n.out.Grad != nil {
+			n.back(n.out)
+		}
+	}
+}
+
+// grad returns v.Grad, allocating it if needed.
+func (v *Var) grad() *lab.Matrix {
+	if v.Grad == nil {
+		v.Grad = lab.NewMatrix(v.Value.Rows, v.Value.Cols)
+	}
+	return v.Grad
+}
+
+// record adds an operation with result value computed from inputs and
+// returns its Var. back is called during Backward with the result's gradient
+// once every operation that used the result has run.
+func record(value *lab.Matrix, back func(out *Var), inputs ...*Var) *Var {
+	t := inputs[0].tape
+	for _, in := range inputs[1:] {
+		if in.tape != t {
+			panic("autograd: Vars from different tapes")
+		}
+	}
+	out := &Var{Value: value, tape: t}
+	t.nodes = append(t.nodes, node{out, back})
+	return out
+}
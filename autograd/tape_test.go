@@ -0,0 +1,74 @@
+package autograd
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"testing"
+)
+
+// numeric checks the gradient of f with respect to every element of the
+// inputs against central differences.
+func numeric(t *testing.T, name string, f func(tape *Tape, in []*Var) *Var, inputs ...*lab.Matrix) {
+	t.Helper()
+	eval := func() float64 {
+		tape := NewTape()
+		vars := make([]*Var, len(inputs))
+		for i, m := range inputs {
+			vars[i] = tape.Var(m)
+		}
+		return f(tape, vars).Value.X[0]
+	}
+	tape := NewTape()
+	vars := make([]*Var, len(inputs))
+	for i, m := range inputs {
+		vars[i] = tape.Var(m)
+	}
+	tape.Backward(f(tape, vars))
+	const h = 1e-6
+	for k, m := range inputs {
+		for i := range m.X {
+			old := m.X[i]
+			m.X[i] = old + h
+			up := eval()
+			m.X[i] = old - h
+			down := eval()
+			m.X[i] = old
+			want := (up - down) / (2 * h)
+			var got float64
+			if vars[k].Grad != nil {
+				got = vars[k].Grad.X[i]
+			}
+			if math.Abs(got-want) > 1e-5*math.Max(1, math.Abs(want)) {
+				t.Errorf("%s: d/dinput%d[%d] = %v, want %v", name, k, i, got, want)
+				return
+			}
+		}
+	}
+}
+
+func TestGradients(t *testing.T) {
+	w, x, b := lab.Gaussian(3, 4), lab.Gaussian(4, 5), lab.Gaussian(3, 1)
+	numeric(t, "mlp", func(_ *Tape, in []*Var) *Var {
+		h := Tanh(AddCol(MatMul(in[0], in[1]), in[2]))
+		return Mean(Square(Sub(Sigmoid(h), Scale(ReLU(h), .5))))
+	}, w, x, b)
+
+	pos := lab.Solid(2, 3, 1.5).Add(lab.Gaussian(2, 3).Scale(.1))
+	numeric(t, "reuse", func(_ *Tape, in []*Var) *Var {
+		// in[0] is used several times, so its gradient must add up.
+		y := Mul(Log(in[0]), Exp(Transpose(Transpose(in[0]))))
+		return Sum(Add(y, MulCol(in[0], in[1])))
+	}, pos, lab.Gaussian(2, 1))
+}
+
+func TestBackwardTwice(t *testing.T) {
+	tape := NewTape()
+	x := tape.Var(lab.Solid(1, 1, 3))
+	y := Square(x)
+	tape.Backward(y)
+	tape.Backward(y)
+	// Input gradients add up, intermediate ones don't.
+	if x.Grad.X[0] != 12 || y.Grad.X[0] != 1 {
+		t.Errorf("got dx %v, dy %v; want 12, 1", x.Grad.X[0], y.Grad.X[0])
+	}
+}
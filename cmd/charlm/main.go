@@ -0,0 +1,209 @@
+package main
+
+import (
+	"github.com/wizgrao/ml/data"
+	"github.com/wizgrao/ml/lab"
+	"github.com/wizgrao/ml/nn"
+
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+var textFile = flag.String("text", "", "text file to train on")
+var context = flag.Int("context", 32, "characters the model sees at once")
+var dim = flag.Int("dim", 32, "model width")
+var heads = flag.Int("heads", 4, "attention heads per block")
+var blocks = flag.Int("blocks", 2, "transformer blocks")
+var batchSize = flag.Int("batch", 16, "windows per batch")
+var steps = flag.Int("steps", 200, "batches per epoch")
+var epochs = flag.Int("epochs", 50, "epochs to train for")
+var seed = flag.Int64("seed", 123456, "experiment seed; initialization, data order and noise are seeded from it")
+var resume = flag.String("resume", "", "checkpoint to resume training from")
+var optimizer = flag.String("optimizer", "adam", "sgd, momentum, nesterov, adam, adamw or rmsprop")
+var rate = flag.Float64("rate", .001, "base learning rate")
+var clipMax = flag.Float64("clipmax", 1, "largest global gradient norm, or 0 for no clipping")
+var sampleLen = flag.Int("sample", 200, "characters to generate after every epoch")
+var temperature = flag.Float64("temperature", .8, "sampling temperature")
+
+func main() {
+	flag.Parse()
+	if *textFile == "" {
+		fmt.Println("Usage: charlm -text file.txt")
+		return
+	}
+	raw, err := os.ReadFile(*textFile)
+	if err != nil {
+		fmt.Println("Error reading text: ", err)
+		return
+	}
+	text := newCorpus(string(raw), *context)
+	if text.Len() <= 0 {
+		fmt.Println("Text is shorter than the context")
+		return
+	}
+	fmt.Printf("%d characters, vocabulary of %d\n", len(text.ids), len(text.vocab))
+
+	v := len(text.vocab)
+	layers := []nn.Layer{
+		nn.NewTimeDistributed(v, nn.NewFCLayer(v, *dim)),
+		&nn.PositionalEncoding{Dim: *dim},
+	}
+	for i := 0; i < *blocks; i++ {
+		layers = append(layers, nn.NewTransformerBlock(*dim, *heads, 4**dim, true))
+	}
+	layers = append(layers, nn.NewLayerNorm(*dim), nn.NewTimeDistributed(*dim, nn.NewFCLayer(*dim, v)))
+	model := &nn.Network{Layers: layers}
+	if err := model.Init(nn.XavierUniform{}, nil, lab.NewRand(*seed, "init")); err != nil {
+		fmt.Println("Error initializing model: ", err)
+		return
+	}
+	sampler := lab.NewRand(*seed, "samples")
+
+	opt, err := nn.NewOptimizer(*optimizer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var clipper nn.Clipper
+	if *clipMax > 0 {
+		clipper = &nn.ClipGlobalNorm{Max: *clipMax}
+	}
+	// Sum over the steps and windows of a batch; the loss per char metric
+	// divides it back out.
+	ce := nn.NewSoftMaxCrossEntropy(v)
+	ce.Reduction = nn.ReduceSum
+	trainer := &nn.Trainer{
+		Model:     model,
+		Loss:      &nn.SequenceLoss{Inner: ce, Dim: v},
+		Data:      &limit{Loader: &data.Loader{Dataset: text, BatchSize: *batchSize, Shuffle: true, Seed: lab.DeriveSeed(*seed, "data"), DropLast: true}, max: *steps},
+		Optimizer: opt,
+		Rate:      *rate,
+		Clipper:   clipper,
+		Seed:      *seed,
+		Callbacks: []nn.Callback{
+			nn.EpochFunc(func(t *nn.Trainer) error {
+				// The loss sums over the steps of every window in a batch.
+				t.Metrics["loss per char"] = t.Metrics["loss"] / float64(*context**batchSize)
+				fmt.Println(text.generate(t.Model, *sampleLen, *temperature, sampler))
+				return nil
+			}),
+			&nn.Logger{W: os.Stdout},
+			&nn.Checkpointer{Pattern: "charlmE%d.ckpt"},
+		},
+	}
+	if *resume != "" {
+		fmt.Println("Resuming from checkpoint")
+		ckpt, err := nn.LoadCheckpoint(*resume)
+		if err != nil {
+			fmt.Println("Error loading checkpoint: ", err)
+			return
+		}
+		if err := trainer.Resume(ckpt); err != nil {
+			fmt.Println("Error resuming: ", err)
+			return
+		}
+	}
+	fmt.Println("Starting Training")
+	if err := trainer.Fit(*epochs); err != nil {
+		fmt.Println("Error training: ", err)
+	}
+}
+
+// corpus is a data.Dataset of every window of context characters in a text.
+// Samples are the one-hot encoded window, context steps of one vocabulary-
+// sized vector each, and targets the classes of the next character at every
+// step.
+type corpus struct {
+	vocab   []rune
+	index   map[rune]int
+	ids     []int
+	context int
+}
+
+func newCorpus(text string, context int) *corpus {
+	c := &corpus{index: map[rune]int{}, context: context}
+	for _, r := range text {
+		id, ok := c.index[r]
+		if !ok {
+			id = len(c.vocab)
+			c.index[r] = id
+			c.vocab = append(c.vocab, r)
+		}
+		c.ids = append(c.ids, id)
+	}
+	return c
+}
+
+func (c *corpus) Len() int {
+	return len(c.ids) - c.context
+}
+
+func (c *corpus) Get(i int) (*lab.Matrix, *lab.Matrix) {
+	y := lab.NewMatrix(c.context, 1)
+	for t := 0; t < c.context; t++ {
+		y.X[t] = float64(c.ids[i+t+1])
+	}
+	return c.oneHot(c.ids[i : i+c.context]), y
+}
+
+func (c *corpus) oneHot(ids []int) *lab.Matrix {
+	x := lab.NewMatrix(len(ids)*len(c.vocab), 1)
+	for t, id := range ids {
+		x.X[t*len(c.vocab)+id] = 1
+	}
+	return x
+}
+
+// generate continues the start of the text by n characters, sampling each
+// with rng from the model's prediction at the last step of the window.
+func (c *corpus) generate(model *nn.Network, n int, temperature float64, rng *rand.Rand) string {
+	window := append([]int{}, c.ids[:c.context]...)
+	var out strings.Builder
+	v := len(c.vocab)
+	for i := 0; i < n; i++ {
+		logits := model.Forward(c.oneHot(window)).X[(c.context-1)*v:]
+		max := logits[0]
+		for _, l := range logits {
+			max = math.Max(max, l)
+		}
+		p := make([]float64, v)
+		var sum float64
+		for k, l := range logits {
+			p[k] = math.Exp((l - max) / temperature)
+			sum += p[k]
+		}
+		next, u := v-1, rng.Float64()*sum
+		for k := range p {
+			if u -= p[k]; u < 0 {
+				next = k
+				break
+			}
+		}
+		out.WriteRune(c.vocab[next])
+		window = append(window[1:], next)
+	}
+	return out.String()
+}
+
+// limit ends every epoch of Loader after max batches.
+type limit struct {
+	*data.Loader
+	max, n int
+}
+
+func (l *limit) Next() (*lab.Matrix, *lab.Matrix) {
+	if l.n >= l.max {
+		return nil, nil
+	}
+	l.n++
+	return l.Loader.Next()
+}
+
+func (l *limit) Reset() {
+	l.n = 0
+	l.Loader.Reset()
+}
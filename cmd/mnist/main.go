@@ -1,120 +1,200 @@
 package main
 
 import (
+	"github.com/wizgrao/ml/data"
 	"github.com/wizgrao/ml/data/mnist"
 	"github.com/wizgrao/ml/lab"
 	"github.com/wizgrao/ml/nn"
 
 	"flag"
 	"fmt"
-	"math/rand"
+	"os"
 	"strconv"
+	"strings"
 )
 
-var loadWeights = flag.String("weights", "", "file to load weights from")
-var trainSet = flag.String("train", "mnist_train.csv", "csv for training data")
-var testSet = flag.String("test", "mnist_test.csv", "csv for test data")
-var seed = flag.Int64("seed", 123456, "Seed for randomness")
+var loadWeights = flag.String("weights", "", "model file to load instead of a fresh network")
+var trainSet = flag.String("train", "mnist", "directory of IDX files or csv for training data")
+var testSet = flag.String("test", "mnist", "directory of IDX files or csv for test data")
+var arch = flag.String("arch", "mlp", "model to train: mlp, bn (mlp with batch normalization) or lenet")
+var dropout = flag.Float64("dropout", 0, "dropout probability after the hidden layer of mlp and bn")
+var initWeights = flag.String("init", "he_normal", "weight initializer for a fresh network, e.g. xavier_uniform or orthogonal, or file:w.json to load the parameters saved by nn.SaveParams")
+var smoothing = flag.Float64("smoothing", 0, "label smoothing of the cross-entropy loss")
+var seed = flag.Int64("seed", 123456, "experiment seed; initialization, data order and noise are seeded from it")
+var resume = flag.String("resume", "", "checkpoint to resume training from")
+var optimizer = flag.String("optimizer", "momentum", "sgd, momentum, nesterov, adam, adamw or rmsprop")
+var rate = flag.Float64("rate", .00001, "base learning rate")
+var schedule = flag.String("schedule", "constant", "learning rate schedule: constant, step, exp, cosine or plateau")
+var warmup = flag.Int("warmup", 0, "steps of linear learning rate warmup")
+var clip = flag.String("clip", "value", "gradient clipping: none, value, norm or global")
+var clipMax = flag.Float64("clipmax", .5, "largest gradient value or norm allowed by -clip")
 
 func main() {
 	flag.Parse()
-	rand.Seed(*seed)
 
 	fmt.Println("Loading training set")
-	trainSet, err := mnist.NewSet(*trainSet)
+	trainSet, err := mnist.Open(*trainSet, true)
 	if err != nil {
 		fmt.Println("Error loading training set: ", err)
 		return
 	}
 	fmt.Println("Loading test set")
-	testSet, err := mnist.NewSet(*testSet)
+	testSet, err := mnist.Open(*testSet, false)
 	if err != nil {
 		fmt.Println("Error loading test set: ", err)
 		return
 	}
-	model := &nn.Network{
-		Layers: []nn.Layer{
-			&nn.Translate{lab.Solid(28*28, 1, -128.0)},
-			&nn.Scale{1.0 / 128.0},
-			nn.NewFCLayer(28*28, 100),
-			&nn.RELU{},
-			nn.NewFCLayer(100, 10),
-		},
+	var model *nn.Network
+	switch *arch {
+	case "mlp":
+		model = &nn.Network{
+			Layers: []nn.Layer{
+				&nn.Translate{lab.Solid(28*28, 1, -128.0)},
+				&nn.Scale{1.0 / 128.0},
+				nn.NewFCLayer(28*28, 100),
+				&nn.RELU{},
+				&nn.Dropout{P: *dropout},
+				nn.NewFCLayer(100, 10),
+			},
+		}
+	case "bn":
+		model = &nn.Network{
+			Layers: []nn.Layer{
+				nn.NewBatchNorm1D(28 * 28),
+				nn.NewFCLayer(28*28, 100),
+				nn.NewBatchNorm1D(100),
+				&nn.RELU{},
+				&nn.Dropout{P: *dropout},
+				nn.NewFCLayer(100, 10),
+			},
+		}
+	case "lenet":
+		model = leNet()
+	default:
+		fmt.Println("Unknown architecture", *arch)
+		return
+	}
+	if path := strings.TrimPrefix(*initWeights, "file:"); path != *initWeights {
+		err = nn.LoadParams(model, path)
+	} else {
+		var initializer nn.Initializer
+		if initializer, err = nn.NewInitializer(*initWeights); err == nil {
+			err = model.Init(initializer, nil, lab.NewRand(*seed, "init"))
+		}
+	}
+	if err != nil {
+		fmt.Println("Error initializing model: ", err)
+		return
 	}
 	if *loadWeights != "" {
 		fmt.Println("Loading weights")
-		err := model.LoadModel(*loadWeights)
+		model, err = nn.LoadModel(*loadWeights)
 		if err != nil {
 			fmt.Println("Error loading model: ", err)
 			return
 		}
 	}
-	s, i := trainSet.NextSample()
-	s.Cols = 28
-	s.Rows = 28
+	opt, err := nn.NewOptimizer(*optimizer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var clipper nn.Clipper
+	if *clip != "none" {
+		clipper, err = nn.NewClipper(*clip, *clipMax)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	sched, err := nn.NewSchedule(*schedule, *rate)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *warmup > 0 {
+		sched = &nn.Warmup{Steps: *warmup, Schedule: sched}
+	}
+	trainSample := &data.Loader{Dataset: trainSet, BatchSize: 500, Shuffle: true, Seed: lab.DeriveSeed(*seed, "train sample")}
+	testSample := &data.Loader{Dataset: testSet, BatchSize: 500, Shuffle: true, Seed: lab.DeriveSeed(*seed, "test sample")}
+	loss := nn.NewSoftMaxCrossEntropy(10)
+	// The default rate is tuned for the loss summed over the batch.
+	loss.Smoothing, loss.Reduction = *smoothing, nn.ReduceSum
+	trainer := &nn.Trainer{
+		Model:     model,
+		Loss:      loss,
+		Data:      &data.Loader{Dataset: trainSet, BatchSize: 10, Shuffle: true, Seed: lab.DeriveSeed(*seed, "data"), Prefetch: 4},
+		Optimizer: opt,
+		Schedule:  sched,
+		Clipper:   clipper,
+		Monitor:   "test error",
+		Seed:      *seed,
+		Callbacks: []nn.Callback{
+			nn.EpochFunc(func(t *nn.Trainer) error {
+				trainAccuracy, trainConfusion := evaluate(t.Model, trainSample)
+				testAccuracy, testConfusion := evaluate(t.Model, testSample)
+				numeral := strconv.FormatInt(int64(t.Epoch-1), 10)
+				trainConfusion.ImWriteBW("trainConfusion" + numeral + ".png")
+				testConfusion.ImWriteBW("testConfusion" + numeral + ".png")
+				t.Metrics["training accuracy"] = trainAccuracy
+				t.Metrics["test accuracy"] = testAccuracy
+				t.Metrics["test error"] = 1 - testAccuracy
+				if clipper != nil {
+					t.Metrics["clip rate"] = clipper.Stats().Rate()
+				}
+				return nil
+			}),
+			&nn.Logger{W: os.Stdout},
+			&nn.Checkpointer{Pattern: "mnistE%d.ckpt"},
+		},
+	}
+	if *resume != "" {
+		fmt.Println("Resuming from checkpoint")
+		ckpt, err := nn.LoadCheckpoint(*resume)
+		if err != nil {
+			fmt.Println("Error loading checkpoint: ", err)
+			return
+		}
+		if err := trainer.Resume(ckpt); err != nil {
+			fmt.Println("Error resuming: ", err)
+			return
+		}
+	}
+	_, i := trainSet.NextSample()
 	grid := make([][]*lab.Matrix, 5)
 	for i := range grid {
 		grid[i] = make([]*lab.Matrix, 6)
 		for j := range grid[i] {
-			grid[i][j], _ = trainSet.NextSample()
-			grid[i][j].Rows = 28
-			grid[i][j].Cols = 28
+			sample, _ := trainSet.NextSample()
+			grid[i][j] = sample.Tensor().Reshape(28, 28).Matrix()
 		}
 	}
 	fmt.Println("Displaying ", i)
 	lab.Grid(grid).ImWriteBW("asdf.png")
 	fmt.Println("Starting Training")
 
-	for i := 0; i < 1000; i++ {
-		train(model, 10, .00001, trainSet)
-		trainAccuracy, trainConfusion := evaluate(model, trainSet)
-		testAccuracy, testConfusion := evaluate(model, testSet)
-		numeral := strconv.FormatInt(int64(i), 10)
-		trainConfusion.ImWriteBW("trainConfusion" + numeral + ".png")
-		testConfusion.ImWriteBW("testConfusion" + numeral + ".png")
-		fmt.Println("Epoch ", i+1, " training accuracy: ", trainAccuracy, " test accuracy: ", testAccuracy)
-		model.SaveModel("mnistE" + numeral + ".json")
-	}
-}
-
-func train(network *nn.Network, batchSize int, rate float64, m *mnist.Set) {
-	loss := nn.NewSoftMaxCrossEntropy(10)
-	m.Reset()
-	for {
-		loss.Reset()
-		var x *lab.Matrix
-		var target int
-		for j := 0; j < batchSize; j++ {
-			x, target = m.NextSample()
-			if x == nil {
-				break
-			}
-			loss.Target = target
-			result := network.Forward(x)
-			loss.Loss(result)
-		}
-		if x == nil {
-			return
-		}
-		network.Backward(loss.Backward())
-		network.Update(rate)
+	if err := trainer.Fit(1000); err != nil {
+		fmt.Println("Error training: ", err)
 	}
 }
 
-func evaluate(network *nn.Network, m *mnist.Set) (float64, *lab.Matrix) {
-	m.Reset()
+// evaluate scores the network on a fresh random batch from l. The Trainer
+// runs callbacks in inference mode, so the network itself is deterministic.
+func evaluate(network *nn.Network, l *data.Loader) (float64, *lab.Matrix) {
+	l.Reset()
 	var correct int
 	var total int
 	confusion := lab.NewMatrix(10, 10)
-	for i := 0; i < 500; i++ {
-		x, t := m.NextSample()
-		if x == nil {
-			break
-		}
+	x, targets := l.Next()
+	if x == nil {
+		return 1, nil
+	}
+	result := network.Forward(x)
+	for j, label := range targets.X {
+		t := int(label)
 		max := 0
-		result := network.Forward(x)
 		for i := 1; i < 10; i++ {
-			if result.Access(i, 0) > result.Access(max, 0) {
+			if result.Access(i, j) > result.Access(max, j) {
 				max = i
 			}
 		}
@@ -124,8 +204,32 @@ func evaluate(network *nn.Network, m *mnist.Set) (float64, *lab.Matrix) {
 		confusion.Set(max, t, confusion.Access(max, t)+1.0)
 		total++
 	}
-	if total == 0 {
-		return 1, nil
-	}
 	return float64(correct) / float64(total), confusion
 }
+
+// leNet returns a LeNet-5 style convolutional network for 28x28 digits.
+func leNet() *nn.Network {
+	in := nn.Shape{C: 1, H: 28, W: 28}
+	conv1 := nn.NewConv2D(in, 6, 5, 1, 2, 1)
+	pool1 := nn.NewMaxPool2D(conv1.OutShape(), 2, 2)
+	conv2 := nn.NewConv2D(pool1.OutShape(), 16, 5, 1, 0, 1)
+	pool2 := nn.NewMaxPool2D(conv2.OutShape(), 2, 2)
+	return &nn.Network{
+		Layers: []nn.Layer{
+			&nn.Translate{lab.Solid(28*28, 1, -128.0)},
+			&nn.Scale{1.0 / 128.0},
+			conv1,
+			&nn.RELU{},
+			pool1,
+			conv2,
+			&nn.RELU{},
+			pool2,
+			&nn.Flatten{In: pool2.OutShape()},
+			nn.NewFCLayer(pool2.OutShape().Size(), 120),
+			&nn.RELU{},
+			nn.NewFCLayer(120, 84),
+			&nn.RELU{},
+			nn.NewFCLayer(84, 10),
+		},
+	}
+}
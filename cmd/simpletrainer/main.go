@@ -2,12 +2,12 @@ package main
 
 import (
 	"fmt"
+	"github.com/wizgrao/ml/data"
 	"github.com/wizgrao/ml/lab"
 	"github.com/wizgrao/ml/nn"
 	"image"
 	"image/color"
 	"image/png"
-	"math/rand"
 	"os"
 )
 
@@ -25,14 +25,29 @@ func main() {
 		},
 	}
 
-	fmt.Println(benchModel(model, 100))
+	fmt.Println(benchModel(model, &data.Circle{N: 100, Seed: -1}))
 
-	for i := 0; i < 1000; i++ {
-		train(model, 1, 100, .00005)
-		fmt.Println(benchModel(model, 1000))
-		drawModel(model, fmt.Sprintf("out%v.png", i))
+	// Every epoch trains on 100 fresh points.
+	circle := &data.Circle{N: 100}
+	trainer := &nn.Trainer{
+		Model:     &model,
+		Loss:      nn.NewSoftMaxCrossEntropy(2),
+		Data:      &data.Loader{Dataset: circle, BatchSize: 1},
+		Optimizer: nn.NewMomentum(.9),
+		Rate:      .00005,
+		Callbacks: []nn.Callback{
+			&nn.Logger{W: os.Stdout, Every: 1},
+			nn.EpochFunc(func(t *nn.Trainer) error {
+				fmt.Println(benchModel(model, &data.Circle{N: 1000, Seed: -1 - int64(t.Epoch)}))
+				drawModel(model, fmt.Sprintf("out%v.png", t.Epoch-1))
+				circle.Seed++
+				return nil
+			}),
+		},
+	}
+	if err := trainer.Fit(1000); err != nil {
+		fmt.Println("Error training: ", err)
 	}
-
 }
 
 func drawModel(model nn.Network, fname string) {
@@ -58,17 +73,6 @@ func drawModel(model nn.Network, fname string) {
 
 }
 
-func getCatMat(matrix *lab.Matrix) int {
-	center := lab.NewVector([]float64{.5, .5}).Col()
-	diff := center.Sub(matrix)
-	dist := diff.Transpose().Multiply(diff).Access(0, 0)
-	if dist < .15 {
-		return 0
-	}
-	return 1
-
-}
-
 func getHot(matrix *lab.Matrix) int {
 	if matrix.X[0] > matrix.X[1] {
 		return 0
@@ -76,40 +80,13 @@ func getHot(matrix *lab.Matrix) int {
 	return 1
 }
 
-func benchModel(network nn.Network, n int) float64 {
+func benchModel(network nn.Network, ds data.Dataset) float64 {
 	var cor int
-	for i := 0; i < n; i++ {
-		vec := &lab.Matrix{
-			X:    []float64{rand.Float64(), rand.Float64()},
-			Cols: 1,
-			Rows: 2,
-		}
-		expected := getCatMat(vec)
-		got := getHot(network.Forward(vec))
-		if expected == got {
+	for i := 0; i < ds.Len(); i++ {
+		x, y := ds.Get(i)
+		if getHot(network.Forward(x)) == int(y.X[0]) {
 			cor++
 		}
 	}
-	return float64(cor) / float64(n)
-}
-
-func train(network nn.Network, batchSize, n int, rate float64) {
-	loss := nn.NewSoftMaxCrossEntropy(2)
-	for i := 0; i < n; i++ {
-		loss.Reset()
-		var losss float64
-		for j := 0; j < batchSize; j++ {
-			vec := &lab.Matrix{
-				X:    []float64{rand.Float64(), rand.Float64()},
-				Cols: 1,
-				Rows: 2,
-			}
-			loss.Target = getCatMat(vec)
-			result := network.Forward(vec)
-			losss = loss.Loss(result)
-		}
-		fmt.Println("loss", losss)
-		network.Backward(loss.Backward())
-		network.Update(rate)
-	}
+	return float64(cor) / float64(ds.Len())
 }
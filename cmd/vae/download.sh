@@ -1,3 +1,15 @@
 #!/bin/bash
-wget https://pjreddie.com/media/files/mnist_train.csv
-wget https://pjreddie.com/media/files/mnist_test.csv
+# Downloads the IDX files of a dataset into a directory of the same name:
+# mnist (default), fashion or kmnist. Pass the directory to -train and -test.
+set -e
+dataset=${1:-mnist}
+case $dataset in
+mnist) base=https://ossci-datasets.s3.amazonaws.com/mnist ;;
+fashion) base=http://fashion-mnist.s3-website.eu-central-1.amazonaws.com ;;
+kmnist) base=http://codh.rois.ac.jp/kmnist/dataset/kmnist ;;
+*) echo "unknown dataset $dataset" >&2; exit 1 ;;
+esac
+mkdir -p $dataset
+for f in train-images-idx3-ubyte train-labels-idx1-ubyte t10k-images-idx3-ubyte t10k-labels-idx1-ubyte; do
+	wget -nc -P $dataset $base/$f.gz
+done
@@ -1,32 +1,38 @@
 package main
 
 import (
+	"github.com/wizgrao/ml/data"
 	"github.com/wizgrao/ml/data/mnist"
 	"github.com/wizgrao/ml/lab"
 	"github.com/wizgrao/ml/nn"
 
 	"flag"
 	"fmt"
-	"math/rand"
+	"os"
 	"strconv"
 )
 
-var loadWeights = flag.String("weights", "", "file to load weights from")
+var loadWeights = flag.String("weights", "", "model file to load instead of a fresh network")
 var name = flag.String("name", "vae", "name of experiment")
-var trainSet = flag.String("train", "mnist_train.csv", "csv for training data")
-var seed = flag.Int64("seed", 123456, "Seed for randomness")
+var trainSet = flag.String("train", "mnist", "directory of IDX files or csv for training data")
+var seed = flag.Int64("seed", 123456, "experiment seed; initialization, data order and noise are seeded from it")
+var resume = flag.String("resume", "", "checkpoint to resume training from")
+var optimizer = flag.String("optimizer", "momentum", "sgd, momentum, nesterov, adam, adamw or rmsprop")
+var rate = flag.Float64("rate", .00001, "base learning rate")
+var schedule = flag.String("schedule", "constant", "learning rate schedule: constant, step, exp, cosine or plateau")
+var warmup = flag.Int("warmup", 0, "steps of linear learning rate warmup")
+var clip = flag.String("clip", "value", "gradient clipping: none, value, norm or global")
+var clipMax = flag.Float64("clipmax", .5, "largest gradient value or norm allowed by -clip")
 
 func main() {
 	flag.Parse()
-	rand.Seed(*seed)
 
 	fmt.Println("Loading training set")
-	trains, err := mnist.NewSet(*trainSet)
+	trains, err := mnist.Open(*trainSet, true)
 	if err != nil {
 		fmt.Println("Error loading training set: ", err)
 		return
 	}
-	fmt.Println("Loading test set")
 	encoder := &nn.Network{
 		Layers: []nn.Layer{
 			&nn.Translate{lab.Solid(28*28, 1, -.5)},
@@ -41,7 +47,6 @@ func main() {
 			nn.NewFCLayer(10, 200),
 			&nn.RELU{},
 			nn.NewFCLayer(200, 28*28),
-			&nn.Sigmoid{},
 		},
 	}
 	model := &nn.Network{
@@ -51,85 +56,157 @@ func main() {
 			decoder,
 		},
 	}
+	if err := model.Init(nn.XavierUniform{}, nil, lab.NewRand(*seed, "init")); err != nil {
+		fmt.Println("Error initializing model: ", err)
+		return
+	}
 	if *loadWeights != "" {
 		fmt.Println("Loading weights")
-		err := model.LoadModel(*loadWeights)
+		model, err = nn.LoadModel(*loadWeights)
 		if err != nil {
 			fmt.Println("Error loading model: ", err)
 			return
 		}
 	}
+	opt, err := nn.NewOptimizer(*optimizer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var clipper nn.Clipper
+	if *clip != "none" {
+		clipper, err = nn.NewClipper(*clip, *clipMax)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	sched, err := nn.NewSchedule(*schedule, *rate)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *warmup > 0 {
+		sched = &nn.Warmup{Steps: *warmup, Schedule: sched}
+	}
+	reconLoss := &nn.BCEWithLogits{}
+	reconLoss.Reduction = nn.ReduceSum
+	vae := &vaeStep{
+		reconLoss: reconLoss,
+		klLoss:    nn.NewNormalKL(10),
+	}
+	trainer := &nn.Trainer{
+		Model:     model,
+		Data:      &data.Loader{Dataset: trains, BatchSize: 1, Shuffle: true, Seed: lab.DeriveSeed(*seed, "data"), Prefetch: 16},
+		Optimizer: opt,
+		Schedule:  sched,
+		Clipper:   clipper,
+		StepFunc:  vae.step,
+		Seed:      *seed,
+	}
+	if *resume != "" {
+		fmt.Println("Resuming from checkpoint")
+		ckpt, err := nn.LoadCheckpoint(*resume)
+		if err != nil {
+			fmt.Println("Error loading checkpoint: ", err)
+			return
+		}
+		if err := trainer.Resume(ckpt); err != nil {
+			fmt.Println("Error resuming: ", err)
+			return
+		}
+	}
+	vae.encoder, vae.reparam, vae.decoder, err = splitVAE(trainer.Model)
+	if err != nil {
+		fmt.Println("Error loading model: ", err)
+		return
+	}
 	grid := make([][]*lab.Matrix, 5)
-	generated := make([][]*lab.Matrix, 5)
+	latents := lab.NewRand(*seed, "samples")
 	for i := range grid {
 		grid[i] = make([]*lab.Matrix, 6)
-		generated[i] = make([]*lab.Matrix, 6)
 		for j := range grid[i] {
-			grid[i][j] = lab.Gaussian(10, 1)
-			generated[i][j] = decoder.Forward(grid[i][j])
-			generated[i][j].Cols = 28
-			generated[i][j].Rows = 28
+			grid[i][j] = lab.GaussianRand(10, 1, latents)
 		}
 	}
-	lab.Grid(generated).ImWriteBW(*name + "start.png")
+	drawSamples(vae.decoder, grid, *name+"start.png")
+	trainer.Callbacks = []nn.Callback{
+		nn.EpochFunc(func(t *nn.Trainer) error {
+			t.Metrics["kl loss"], t.Metrics["recon loss"] = vae.kl, vae.recon
+			vae.kl, vae.recon = 0, 0
+			if clipper != nil {
+				t.Metrics["clip rate"] = clipper.Stats().Rate()
+			}
+			drawSamples(vae.decoder, grid, *name+strconv.Itoa(t.Epoch-1)+"start.png")
+			return nil
+		}),
+		&nn.Logger{W: os.Stdout},
+		&nn.Checkpointer{Pattern: *name + "E%d.ckpt"},
+	}
 	fmt.Println("Starting Training")
+	if err := trainer.Fit(1000); err != nil {
+		fmt.Println("Error training: ", err)
+	}
+}
 
-	for i := 0; i < 1000; i++ {
-		kl, recon := train(encoder, reparam, decoder, 1, .00001, trains)
-		numeral := strconv.FormatInt(int64(i), 10)
-		fmt.Println("Epoch ", i+1, " kl loss: ", kl, " recon loss: ", recon)
-		model.SaveModel(*name + "E" + numeral + ".json")
-		for i := range grid {
-			for j := range grid[i] {
-				generated[i][j] = decoder.Forward(grid[i][j])
-				generated[i][j].Cols = 28
-				generated[i][j].Rows = 28
-			}
+// drawSamples decodes every latent vector of grid and writes the images to
+// fname.
+func drawSamples(decoder nn.Layer, grid [][]*lab.Matrix, fname string) {
+	generated := make([][]*lab.Matrix, len(grid))
+	for i := range grid {
+		generated[i] = make([]*lab.Matrix, len(grid[i]))
+		for j := range grid[i] {
+			pixels := (&nn.Sigmoid{}).Forward(decoder.Forward(grid[i][j]))
+			generated[i][j] = pixels.Tensor().Reshape(28, 28).Matrix()
 		}
-		lab.Grid(generated).ImWriteBW(*name + numeral + "start.png")
 	}
+	lab.Grid(generated).ImWriteBW(fname)
 }
 
-func train(encoder, reparam, decoder nn.Layer, batchSize int, rate float64, m *mnist.Set) (float64, float64) {
-	reconLoss := nn.NewBinaryLogProbLoss(28 * 28)
-	klLoss := nn.NewNormalKL(10)
-	m.Reset()
-	var klTotal float64
-	var reconTotal float64
-	fmt.Print("Starting...")
-	var ctr int
-	for {
-		ctr++
-		fmt.Print("\r", ctr, " training!")
-		var kl float64
-		var recon float64
-		reconLoss.Reset()
-		klLoss.Reset()
-		var x *lab.Matrix
-		for j := 0; j < batchSize; j++ {
-			x, _ = m.NextSample()
-			if x == nil {
-				break
-			}
-			x = x.Scale(1.0 / 256.0)
-			reconLoss.Target = x
-			q := encoder.Forward(x)
-			kl = klLoss.Loss(q)
-			z := reparam.Forward(q)
-			xHat := decoder.Forward(z)
-			recon = reconLoss.Loss(xHat)
-		}
-		if x == nil {
-			fmt.Println()
-			return klTotal, reconTotal
+// splitVAE returns the encoder, reparameterization and decoder stages of a
+// model built by this command. The decoder puts out logits; the Sigmoid that
+// older models end it with is dropped.
+func splitVAE(model *nn.Network) (*nn.Network, *nn.Reparam, *nn.Network, error) {
+	if len(model.Layers) != 3 {
+		return nil, nil, nil, fmt.Errorf("expected encoder, reparam and decoder, got %d layers", len(model.Layers))
+	}
+	encoder, ok1 := model.Layers[0].(*nn.Network)
+	reparam, ok2 := model.Layers[1].(*nn.Reparam)
+	decoder, ok3 := model.Layers[2].(*nn.Network)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, nil, nil, fmt.Errorf("not an encoder/reparam/decoder model")
+	}
+	if n := len(decoder.Layers); n > 0 {
+		if _, ok := decoder.Layers[n-1].(*nn.Sigmoid); ok {
+			decoder.Layers = decoder.Layers[:n-1]
 		}
-		klTotal += kl
-		reconTotal += recon
-		encoder.Backward(klLoss.Backward())
-		encoder.Backward(reparam.Backward(decoder.Backward(reconLoss.Backward())))
-		encoder.Update(rate)
-		reparam.Update(rate)
-		decoder.Update(rate)
 	}
+	return encoder, reparam, decoder, nil
+}
+
+// vaeStep is the training step of the VAE: the KL loss is applied to the
+// encoder output and the reconstruction loss, on the decoder's logits, is
+// backpropagated through the whole model. It keeps per-epoch totals of both losses.
+type vaeStep struct {
+	encoder, decoder *nn.Network
+	reparam          *nn.Reparam
+	reconLoss        *nn.BCEWithLogits
+	klLoss           *nn.NormalKL
+	kl, recon        float64
+}
 
+func (v *vaeStep) step(x, _ *lab.Matrix) float64 {
+	v.reconLoss.Reset()
+	v.klLoss.Reset()
+	x = x.Scale(1.0 / 256.0)
+	v.reconLoss.SetTarget(x)
+	q := v.encoder.Forward(x)
+	kl := v.klLoss.Loss(q)
+	xHat := v.decoder.Forward(v.reparam.Forward(q))
+	recon := v.reconLoss.Loss(xHat)
+	v.encoder.Backward(v.klLoss.Backward())
+	v.encoder.Backward(v.reparam.Backward(v.decoder.Backward(v.reconLoss.Backward())))
+	v.kl += kl
+	v.recon += recon
+	return kl + recon
 }
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"io"
+	"os"
+)
+
+// CSV is a dataset of numeric CSV rows, one sample per row, read lazily from
+// the file: opening it validates every row and records where each starts,
+// and Get parses only the row it returns. Samples have a 1x1 target unless
+// the label column is -1.
+type CSV struct {
+	file    *os.File
+	opts    lab.CSVOptions
+	offsets []int64
+	size    int64
+}
+
+// NewCSV opens fileName, using column labelCol as the target, or no target if
+// labelCol is negative.
+func NewCSV(fileName string, labelCol int) (*CSV, error) {
+	if labelCol < 0 {
+		labelCol = -1
+	}
+	return OpenCSV(fileName, lab.CSVOptions{LabelCol: labelCol})
+}
+
+// OpenCSV opens fileName with the layout given by opts. The file stays open
+// until Close.
+func OpenCSV(fileName string, opts lab.CSVOptions) (*CSV, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	c := &CSV{file: f, opts: opts}
+	r := lab.NewCSVReader(f, opts)
+	for {
+		_, _, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %v", fileName, err)
+		}
+		c.offsets = append(c.offsets, r.Offset())
+	}
+	if c.size, err = f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Rows are read on their own from now on, so there is no header to skip.
+	c.opts.Header = false
+	return c, nil
+}
+
+func (c *CSV) Close() error {
+	return c.file.Close()
+}
+
+func (c *CSV) Len() int {
+	return len(c.offsets)
+}
+
+// Get parses row i. It panics if the file can no longer be read.
+func (c *CSV) Get(i int) (*lab.Matrix, *lab.Matrix) {
+	off := c.offsets[i]
+	r := lab.NewCSVReader(io.NewSectionReader(c.file, off, c.size-off), c.opts)
+	x, label, err := r.Read()
+	if err != nil {
+		panic(fmt.Sprintf("data: reading csv row %d: %v", i, err))
+	}
+	if c.opts.LabelCol < 0 {
+		return lab.NewVector(x).Col(), nil
+	}
+	return lab.NewVector(x).Col(), lab.Solid(1, 1, label)
+}
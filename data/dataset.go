@@ -0,0 +1,154 @@
+// Package data provides datasets and a loader that batches them for training.
+package data
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math/rand"
+	"strconv"
+)
+
+// Dataset is a collection of samples addressed by index. Get returns the
+// features of sample i as a column vector and its target, also as a column
+// vector, or nil for unlabelled data.
+type Dataset interface {
+	Len() int
+	Get(i int) (x, y *lab.Matrix)
+}
+
+// Loader batches a Dataset for nn.Trainer. Each epoch, started by Reset, walks
+// the dataset once, in an order shuffled from Seed and the epoch number if
+// Shuffle is set. SetEpoch picks the epoch number, so a resumed run can
+// continue with the order it left off at. Batches stack samples as columns, so
+// x is features x batch and y is targets x batch. The last batch may be short
+// unless DropLast is set. With Prefetch > 0 a goroutine assembles up to that
+// many batches ahead of the caller; Get must then be safe to call from that
+// goroutine.
+type Loader struct {
+	Dataset   Dataset
+	BatchSize int
+	Shuffle   bool
+	Seed      int64
+	DropLast  bool
+	Prefetch  int
+
+	epoch   int
+	order   []int
+	pos     int
+	started bool
+	batches chan batch
+	done    chan struct{}
+}
+
+type batch struct {
+	x, y *lab.Matrix
+}
+
+// Reset starts a new epoch.
+func (l *Loader) Reset() {
+	l.stop()
+	l.order = l.permutation()
+	l.epoch++
+	l.pos = 0
+	l.started = true
+	if l.Prefetch > 0 {
+		l.batches = make(chan batch, l.Prefetch)
+		l.done = make(chan struct{})
+		go l.produce(l.order, l.batches, l.done)
+	}
+}
+
+// Next returns the next batch of the epoch, or nils once it is over. The first
+// call starts an epoch if Reset hasn't been called.
+func (l *Loader) Next() (*lab.Matrix, *lab.Matrix) {
+	if !l.started {
+		l.Reset()
+	}
+	if l.Prefetch > 0 {
+		b, ok := <-l.batches
+		if !ok {
+			return nil, nil
+		}
+		return b.x, b.y
+	}
+	b := l.assemble(l.order, &l.pos)
+	return b.x, b.y
+}
+
+// Epoch returns the number of epochs started so far.
+func (l *Loader) Epoch() int {
+	return l.epoch
+}
+
+// SetEpoch sets the number of epochs started so far, so the next Reset
+// starts epoch n, counting from 0.
+func (l *Loader) SetEpoch(n int) {
+	l.epoch = n
+}
+
+func (l *Loader) permutation() []int {
+	n := l.Dataset.Len()
+	if !l.Shuffle {
+		order := make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		return order
+	}
+	return rand.New(rand.NewSource(lab.DeriveSeed(l.Seed, "epoch "+strconv.Itoa(l.epoch)))).Perm(n)
+}
+
+// stop ends the producer of the current epoch, if any.
+func (l *Loader) stop() {
+	if l.done != nil {
+		close(l.done)
+		l.done = nil
+	}
+}
+
+func (l *Loader) produce(order []int, out chan<- batch, done <-chan struct{}) {
+	var pos int
+	for {
+		b := l.assemble(order, &pos)
+		if b.x == nil {
+			close(out)
+			return
+		}
+		select {
+		case out <- b:
+		case <-done:
+			return
+		}
+	}
+}
+
+// assemble stacks the samples order[*pos:*pos+BatchSize] and advances pos.
+func (l *Loader) assemble(order []int, pos *int) batch {
+	n := l.BatchSize
+	if n <= 0 {
+		n = 1
+	}
+	if rest := len(order) - *pos; rest < n {
+		if rest == 0 || l.DropLast {
+			return batch{}
+		}
+		n = rest
+	}
+	indices := order[*pos : *pos+n]
+	*pos += n
+
+	var x, y *lab.Matrix
+	for j, i := range indices {
+		xi, yi := l.Dataset.Get(i)
+		if j == 0 {
+			x = lab.NewMatrix(xi.Rows, n)
+			if yi != nil {
+				y = lab.NewMatrix(yi.Rows, n)
+			}
+		}
+		x.Col(j).SetV(xi.Col(0))
+		if y != nil {
+			y.Col(j).SetV(yi.Col(0))
+		}
+	}
+	return batch{x, y}
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// counting is a dataset whose sample i is the column [i, -i] with target i.
+type counting int
+
+func (c counting) Len() int {
+	return int(c)
+}
+
+func (c counting) Get(i int) (*lab.Matrix, *lab.Matrix) {
+	return lab.NewVector([]float64{float64(i), -float64(i)}).Col(), lab.Solid(1, 1, float64(i))
+}
+
+// epoch collects the targets of every batch of one epoch of l.
+func epoch(l *Loader) [][]float64 {
+	l.Reset()
+	var batches [][]float64
+	for {
+		x, y := l.Next()
+		if x == nil {
+			return batches
+		}
+		if x.Rows != 2 || x.Cols != y.Cols || x.Access(0, 0) != y.X[0] {
+			panic("batch doesn't match its targets")
+		}
+		batches = append(batches, y.X)
+	}
+}
+
+func TestLoader(t *testing.T) {
+	l := &Loader{Dataset: counting(7), BatchSize: 3}
+	want := [][]float64{{0, 1, 2}, {3, 4, 5}, {6}}
+	if got := epoch(l); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	l.DropLast = true
+	if got := epoch(l); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("DropLast: got %v, want %v", got, want[:2])
+	}
+
+	a := &Loader{Dataset: counting(50), BatchSize: 4, Shuffle: true, Seed: 3}
+	b := &Loader{Dataset: counting(50), BatchSize: 4, Shuffle: true, Seed: 3, Prefetch: 2}
+	first := epoch(a)
+	if !reflect.DeepEqual(first, epoch(b)) {
+		t.Errorf("prefetching changed the batches")
+	}
+	second := epoch(a)
+	if reflect.DeepEqual(first, second) {
+		t.Errorf("two epochs were shuffled the same way")
+	}
+	// A resumed loader picks up the order of the epoch it is set to.
+	resumed := &Loader{Dataset: counting(50), BatchSize: 4, Shuffle: true, Seed: 3}
+	resumed.SetEpoch(1)
+	if !reflect.DeepEqual(second, epoch(resumed)) {
+		t.Errorf("epoch 1 after SetEpoch(1) differs from the second epoch")
+	}
+
+	// Abandoning an epoch part way must not leak a blocked producer into the
+	// next one.
+	b.Reset()
+	b.Next()
+	if got := epoch(b); len(got) != 13 {
+		t.Errorf("got %d batches after an abandoned epoch, want 13", len(got))
+	}
+}
+
+func TestCSV(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "d.csv")
+	if err := os.WriteFile(fname, []byte("1,2,3\n4,5,6\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := NewCSV(fname, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	x, y := ds.Get(1)
+	if ds.Len() != 2 || !reflect.DeepEqual(x.X, []float64{4, 6}) || y.X[0] != 5 {
+		t.Errorf("got len %d, x %v, y %v", ds.Len(), x.X, y.X)
+	}
+
+	// Quoted fields may span lines, so rows are found by offset.
+	if err := os.WriteFile(fname, []byte("x,y\n\"1\n\",2\n,3\n4,5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ds, err = OpenCSV(fname, lab.CSVOptions{Header: true, LabelCol: 1, Missing: lab.MissingSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	if ds.Len() != 2 {
+		t.Fatalf("got %d rows, want 2", ds.Len())
+	}
+	if x, y := ds.Get(1); x.X[0] != 4 || y.X[0] != 5 {
+		t.Errorf("row 1: x %v, y %v", x.X, y.X)
+	}
+}
+
+func TestCircle(t *testing.T) {
+	c := &Circle{N: 200}
+	var inside int
+	for i := 0; i < c.Len(); i++ {
+		x, y := c.Get(i)
+		x2, _ := c.Get(i)
+		if x.X[0] != x2.X[0] {
+			t.Fatal("Circle samples aren't deterministic")
+		}
+		if int(y.X[0]) != CircleClass(x) {
+			t.Fatal("wrong label")
+		}
+		if y.X[0] == 0 {
+			inside++
+		}
+	}
+	// The circle covers pi * .15 of the square.
+	if inside < 70 || inside > 120 {
+		t.Errorf("%d of 200 points inside the circle", inside)
+	}
+}
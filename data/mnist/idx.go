@@ -0,0 +1,213 @@
+package mnist
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// IDX element types, from the third byte of the magic number.
+const (
+	idxUint8   = 0x08
+	idxInt8    = 0x09
+	idxInt16   = 0x0B
+	idxInt32   = 0x0C
+	idxFloat32 = 0x0D
+	idxFloat64 = 0x0E
+)
+
+var idxSizes = map[byte]int{
+	idxUint8:   1,
+	idxInt8:    1,
+	idxInt16:   2,
+	idxInt32:   4,
+	idxFloat32: 4,
+	idxFloat64: 8,
+}
+
+// IDX is a decoded IDX file: the dimensions from its header and its elements
+// in row-major order.
+type IDX struct {
+	Dims []int
+	Data []float64
+}
+
+// ReadIDX decodes an IDX file as used by MNIST, Fashion-MNIST and KMNIST.
+// Gzip-compressed input is detected and decompressed.
+func ReadIDX(r io.Reader) (*IDX, error) {
+	br := bufio.NewReader(r)
+	if head, err := br.Peek(2); err == nil && head[0] == 0x1f && head[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, fmt.Errorf("mnist: reading IDX magic number: %v", err)
+	}
+	size, ok := idxSizes[magic[2]]
+	if magic[0] != 0 || magic[1] != 0 || !ok {
+		return nil, fmt.Errorf("mnist: bad IDX magic number % x", magic)
+	}
+	if magic[3] == 0 {
+		return nil, errors.New("mnist: IDX file has no dimensions")
+	}
+
+	dims := make([]int, magic[3])
+	n := 1
+	for i := range dims {
+		var d uint32
+		if err := binary.Read(br, binary.BigEndian, &d); err != nil {
+			return nil, fmt.Errorf("mnist: reading IDX dimensions: %v", err)
+		}
+		if d > math.MaxInt32 || n*int(d) > 1<<31 {
+			return nil, fmt.Errorf("mnist: IDX dimension %d too large", d)
+		}
+		dims[i] = int(d)
+		n *= int(d)
+	}
+
+	// Read in chunks rather than trusting the header with one allocation,
+	// so a short file can't make us reserve memory for data it doesn't hold.
+	const chunk = 1 << 16
+	data := make([]float64, 0, idxMin(n, chunk))
+	buf := make([]byte, idxMin(n, chunk)*size)
+	for len(data) < n {
+		k := idxMin(n-len(data), chunk)
+		if _, err := io.ReadFull(br, buf[:k*size]); err != nil {
+			return nil, fmt.Errorf("mnist: IDX data shorter than its %v dimensions: %v", dims, err)
+		}
+		for i := 0; i < k; i++ {
+			data = append(data, idxValue(magic[2], buf[i*size:]))
+		}
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("mnist: IDX data longer than its %v dimensions", dims)
+	}
+	return &IDX{Dims: dims, Data: data}, nil
+}
+
+// idxValue decodes the element of type t at the start of b.
+func idxValue(t byte, b []byte) float64 {
+	be := binary.BigEndian
+	switch t {
+	case idxInt8:
+		return float64(int8(b[0]))
+	case idxInt16:
+		return float64(int16(be.Uint16(b)))
+	case idxInt32:
+		return float64(int32(be.Uint32(b)))
+	case idxFloat32:
+		return float64(math.Float32frombits(be.Uint32(b)))
+	case idxFloat64:
+		return math.Float64frombits(be.Uint64(b))
+	}
+	return float64(b[0])
+}
+
+func idxMin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func readIDXFile(fileName string) (*IDX, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx, err := ReadIDX(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return idx, nil
+}
+
+// LoadIDX builds a set from an IDX image file (n x rows x cols) and the
+// matching IDX label file (n), either of which may be gzipped. Pixels keep
+// their 0-255 range, as with NewSet.
+func LoadIDX(images, labels string) (*Set, error) {
+	im, err := readIDXFile(images)
+	if err != nil {
+		return nil, err
+	}
+	lb, err := readIDXFile(labels)
+	if err != nil {
+		return nil, err
+	}
+	if len(im.Dims) != 3 {
+		return nil, fmt.Errorf("mnist: %s: images have dimensions %v, want n x rows x cols", images, im.Dims)
+	}
+	if len(lb.Dims) != 1 {
+		return nil, fmt.Errorf("mnist: %s: labels have dimensions %v, want n", labels, lb.Dims)
+	}
+	n, pixels := im.Dims[0], im.Dims[1]*im.Dims[2]
+	if lb.Dims[0] != n {
+		return nil, fmt.Errorf("mnist: %d images but %d labels", n, lb.Dims[0])
+	}
+
+	mat := lab.NewMatrix(pixels+1, n)
+	for j := 0; j < n; j++ {
+		mat.X[j] = lb.Data[j]
+		for i, p := range im.Data[j*pixels : (j+1)*pixels] {
+			mat.X[(i+1)*n+j] = p
+		}
+	}
+	return newSet(mat), nil
+}
+
+// LoadDir loads the training or test split from a directory holding the IDX
+// files under their usual names, e.g. train-images-idx3-ubyte.gz and
+// train-labels-idx1-ubyte.gz for training. MNIST, Fashion-MNIST and KMNIST
+// all ship with these names; uncompressed copies work too.
+func LoadDir(dir string, train bool) (*Set, error) {
+	prefix := "t10k"
+	if train {
+		prefix = "train"
+	}
+	images, err := findIDX(dir, prefix+"-images-idx3-ubyte")
+	if err != nil {
+		return nil, err
+	}
+	labels, err := findIDX(dir, prefix+"-labels-idx1-ubyte")
+	if err != nil {
+		return nil, err
+	}
+	return LoadIDX(images, labels)
+}
+
+func findIDX(dir, name string) (string, error) {
+	for _, fname := range []string{name + ".gz", name} {
+		path := filepath.Join(dir, fname)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("mnist: no %s or %s.gz in %s", name, name, dir)
+}
+
+// Open loads a set from either a CSV file, as with NewSet, or a directory of
+// IDX files, as with LoadDir.
+func Open(path string, train bool) (*Set, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return LoadDir(path, train)
+	}
+	return NewSet(path)
+}
@@ -0,0 +1,127 @@
+package mnist
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// idxFile encodes unsigned byte data as an IDX file with the given dimensions.
+func idxFile(dims []int, data []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0, 0, idxUint8, byte(len(dims))})
+	for _, d := range dims {
+		binary.Write(&b, binary.BigEndian, uint32(d))
+	}
+	b.Write(data)
+	return b.Bytes()
+}
+
+func gzipped(data []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	// Three 2x2 images whose pixels are 10*label + pixel index.
+	var pixels []byte
+	labels := []byte{7, 0, 3}
+	for _, l := range labels {
+		for p := 0; p < 4; p++ {
+			pixels = append(pixels, 10*l+byte(p))
+		}
+	}
+	files := map[string][]byte{
+		"train-images-idx3-ubyte.gz": gzipped(idxFile([]int{3, 2, 2}, pixels)),
+		"train-labels-idx1-ubyte":    idxFile([]int{3}, labels),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set, err := Open(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 3 {
+		t.Fatalf("got %d samples, want 3", set.Len())
+	}
+	for i, l := range labels {
+		x, y := set.Get(i)
+		if y.X[0] != float64(l) || x.Rows != 4 {
+			t.Fatalf("sample %d: label %v, %d pixels", i, y.X[0], x.Rows)
+		}
+		for p, v := range x.X {
+			if v != float64(10*l+byte(p)) {
+				t.Errorf("sample %d pixel %d = %v, want %v", i, p, v, 10*l+byte(p))
+			}
+		}
+	}
+
+	if _, err := LoadDir(dir, false); err == nil {
+		t.Error("loaded a test split that doesn't exist")
+	}
+
+	// Seeding fixes the order NextBatch walks the samples in.
+	order := func(seed int64) []int {
+		set.Seed(seed)
+		_, labels := set.NextBatch(3)
+		return labels
+	}
+	first := order(5)
+	if again := order(5); !equalInts(first, again) {
+		t.Errorf("seed 5 gave orders %v and %v", first, again)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadIDXErrors(t *testing.T) {
+	cases := map[string][]byte{
+		"magic":  {1, 0, idxUint8, 1, 0, 0, 0, 1, 5},
+		"type":   {0, 0, 0x42, 1, 0, 0, 0, 1, 5},
+		"short":  idxFile([]int{2, 2}, []byte{1, 2, 3}),
+		"long":   idxFile([]int{1}, []byte{1, 2}),
+		"header": {0, 0, idxUint8, 2, 0, 0},
+		// A header claiming 2^31 float64s must fail on the missing data
+		// without first allocating 16 GiB.
+		"huge": {0, 0, idxFloat64, 1, 0x80, 0, 0, 0, 1, 2, 3},
+	}
+	for name, data := range cases {
+		if _, err := ReadIDX(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	dir := t.TempDir()
+	images := filepath.Join(dir, "images")
+	labels := filepath.Join(dir, "labels")
+	os.WriteFile(images, idxFile([]int{2, 1, 1}, []byte{1, 2}), 0644)
+	os.WriteFile(labels, idxFile([]int{3}, []byte{1, 2, 3}), 0644)
+	if _, err := LoadIDX(images, labels); err == nil || !strings.Contains(err.Error(), "labels") {
+		t.Errorf("mismatched counts: got error %v", err)
+	}
+	if _, err := LoadIDX(labels, labels); err == nil {
+		t.Error("loaded labels as images")
+	}
+}
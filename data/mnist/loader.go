@@ -6,10 +6,20 @@ import (
 	"math/rand"
 )
 
+// Set holds labelled samples and walks them in a shuffled order with
+// NextSample and NextBatch. The order comes from the set's own generator,
+// seeded with 1 unless Seed is called.
 type Set struct {
 	i    int
 	mat  *lab.Matrix
 	perm []int
+	rng  *rand.Rand
+}
+
+func newSet(mat *lab.Matrix) *Set {
+	m := &Set{mat: mat, rng: rand.New(rand.NewSource(1))}
+	m.perm = m.rng.Perm(mat.Cols)
+	return m
 }
 
 func NewSet(fileName string) (*Set, error) {
@@ -17,11 +27,7 @@ func NewSet(fileName string) (*Set, error) {
 	if err != nil {
 		return nil, err
 	}
-	mat = mat.Transpose()
-	return &Set{
-		mat:  mat,
-		perm: rand.Perm(mat.Cols),
-	}, nil
+	return newSet(mat.Transpose()), nil
 }
 
 func (m *Set) NextSample() (*lab.Matrix, int) {
@@ -30,12 +36,52 @@ func (m *Set) NextSample() (*lab.Matrix, int) {
 	}
 	index := m.perm[m.i]
 	label := int(math.Round(m.mat.Access(0, index)))
-	x := m.mat.SubMatrix(1, index, 28*28, 1)
+	x := m.mat.SubMatrix(1, index, m.mat.Rows-1, 1)
 	m.i++
 	return x, label
 }
 
+// NextBatch returns up to n samples as the columns of a features x n matrix
+// along with their labels. The last batch of an epoch may be smaller; once the set is
+// exhausted NextBatch returns nil.
+func (m *Set) NextBatch(n int) (*lab.Matrix, []int) {
+	if m.i >= m.mat.Cols {
+		return nil, nil
+	}
+	if rest := m.mat.Cols - m.i; n > rest {
+		n = rest
+	}
+	x := lab.NewMatrix(m.mat.Rows-1, n)
+	labels := make([]int, n)
+	for j := 0; j < n; j++ {
+		index := m.perm[m.i+j]
+		labels[j] = int(math.Round(m.mat.Access(0, index)))
+		for i := 1; i < m.mat.Rows; i++ {
+			x.Set(i-1, j, m.mat.Access(i, index))
+		}
+	}
+	m.i += n
+	return x, labels
+}
+
+// Len and Get implement data.Dataset. Get returns the pixels of sample i in
+// file order and its label as a 1x1 matrix.
+func (m *Set) Len() int {
+	return m.mat.Cols
+}
+
+func (m *Set) Get(i int) (*lab.Matrix, *lab.Matrix) {
+	return m.mat.SubMatrix(1, i, m.mat.Rows-1, 1), m.mat.SubMatrix(0, i, 1, 1)
+}
+
 func (m *Set) Reset() {
 	m.i = 0
-	m.perm = rand.Perm(m.mat.Cols)
+	m.perm = m.rng.Perm(m.mat.Cols)
+}
+
+// Seed reseeds the set's generator and starts a new pass in an order drawn
+// from it.
+func (m *Set) Seed(seed int64) {
+	m.rng = rand.New(rand.NewSource(seed))
+	m.Reset()
 }
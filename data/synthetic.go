@@ -0,0 +1,34 @@
+package data
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math/rand"
+)
+
+// Circle is the synthetic task of cmd/simpletrainer: N points drawn uniformly
+// from the unit square, labelled 0 inside the circle of squared radius .15
+// around (.5, .5) and 1 outside. Sample i depends only on Seed and i, so
+// changing Seed gives a fresh set of points.
+type Circle struct {
+	N    int
+	Seed int64
+}
+
+func (c *Circle) Len() int {
+	return c.N
+}
+
+func (c *Circle) Get(i int) (*lab.Matrix, *lab.Matrix) {
+	r := rand.New(rand.NewSource(c.Seed*int64(c.N) + int64(i)))
+	x := lab.NewVector([]float64{r.Float64(), r.Float64()}).Col()
+	return x, lab.Solid(1, 1, float64(CircleClass(x)))
+}
+
+// CircleClass labels a point the way Circle does.
+func CircleClass(x *lab.Matrix) int {
+	dx, dy := x.X[0]-.5, x.X[1]-.5
+	if dx*dx+dy*dy < .15 {
+		return 0
+	}
+	return 1
+}
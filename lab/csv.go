@@ -0,0 +1,170 @@
+package lab
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Missing says what a CSVReader does with empty, "NaN" or "NA" fields.
+type Missing int
+
+const (
+	// MissingError fails on the row with the missing value.
+	MissingError Missing = iota
+	// MissingSkip drops rows with missing values.
+	MissingSkip
+	// MissingFill replaces missing values with CSVOptions.Fill.
+	MissingFill
+)
+
+// CSVOptions describes the layout of a numeric CSV file. The zero value reads
+// comma-separated rows without a header, using column 0 as the label; set
+// LabelCol to -1 for files without labels.
+type CSVOptions struct {
+	Header bool
+	// Comma is the field delimiter, ',' if zero.
+	Comma rune
+	// LabelCol is the column returned as the label, or -1 for none.
+	LabelCol int
+	// Columns are the feature columns, in order. If nil every column but the
+	// label is a feature.
+	Columns []int
+	Missing Missing
+	Fill    float64
+}
+
+// CSVReader reads numeric CSV rows one at a time. Every row must have as many
+// fields as the first.
+type CSVReader struct {
+	opts   CSVOptions
+	r      *csv.Reader
+	header []string
+	width  int
+	line   int
+	offset int64
+}
+
+func NewCSVReader(r io.Reader, opts CSVOptions) *CSVReader {
+	cr := csv.NewReader(r)
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	return &CSVReader{opts: opts, r: cr}
+}
+
+// Header returns the header row, once the first row has been read.
+func (c *CSVReader) Header() []string {
+	return c.header
+}
+
+// Line returns the line number of the last row returned by Read.
+func (c *CSVReader) Line() int {
+	return c.line
+}
+
+// Offset returns the byte offset in the input of the last row returned by
+// Read, so a reader over the input from that offset reads the same row.
+func (c *CSVReader) Offset() int64 {
+	return c.offset
+}
+
+// Read returns the features and label of the next row, skipping rows with
+// missing values if asked to. It returns io.EOF at the end of the input.
+func (c *CSVReader) Read() ([]float64, float64, error) {
+	for {
+		offset := c.r.InputOffset()
+		record, err := c.r.Read()
+		if err != nil {
+			return nil, 0, err
+		}
+		line, _ := c.r.FieldPos(0)
+		if c.width == 0 {
+			if err := c.setWidth(len(record), line); err != nil {
+				return nil, 0, err
+			}
+			if c.opts.Header {
+				c.header = append([]string{}, record...)
+				continue
+			}
+		}
+		if len(record) != c.width {
+			return nil, 0, fmt.Errorf("lab: csv line %d: %d fields, want %d", line, len(record), c.width)
+		}
+		x, label, ok, err := c.parse(record, line)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			c.line, c.offset = line, offset
+			return x, label, nil
+		}
+	}
+}
+
+func (c *CSVReader) setWidth(width, line int) error {
+	c.width = width
+	if c.opts.LabelCol >= width {
+		return fmt.Errorf("lab: csv line %d: label column %d out of range for %d fields", line, c.opts.LabelCol, width)
+	}
+	for _, col := range c.opts.Columns {
+		if col < 0 || col >= width {
+			return fmt.Errorf("lab: csv line %d: column %d out of range for %d fields", line, col, width)
+		}
+	}
+	return nil
+}
+
+// parse converts the selected fields of record, reporting false if the row
+// should be skipped.
+func (c *CSVReader) parse(record []string, line int) ([]float64, float64, bool, error) {
+	cols := c.opts.Columns
+	if cols == nil {
+		cols = make([]int, 0, c.width)
+		for i := 0; i < c.width; i++ {
+			if i != c.opts.LabelCol {
+				cols = append(cols, i)
+			}
+		}
+	}
+	x := make([]float64, len(cols))
+	for i, col := range cols {
+		v, ok, err := c.field(record, col, line)
+		if !ok {
+			return nil, 0, false, err
+		}
+		x[i] = v
+	}
+	var label float64
+	if c.opts.LabelCol >= 0 {
+		v, ok, err := c.field(record, c.opts.LabelCol, line)
+		if !ok {
+			return nil, 0, false, err
+		}
+		label = v
+	}
+	return x, label, true, nil
+}
+
+func (c *CSVReader) field(record []string, col, line int) (float64, bool, error) {
+	s := strings.TrimSpace(record[col])
+	switch strings.ToLower(s) {
+	case "", "nan", "na":
+		switch c.opts.Missing {
+		case MissingSkip:
+			return 0, false, nil
+		case MissingFill:
+			return c.opts.Fill, true, nil
+		}
+		return 0, false, fmt.Errorf("lab: csv line %d column %d: missing value", line, col)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("lab: csv line %d column %d: %q is not a number", line, col, s)
+	}
+	return v, true, nil
+}
@@ -0,0 +1,89 @@
+package lab
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, input string, opts CSVOptions) ([][]float64, []float64, error) {
+	t.Helper()
+	r := NewCSVReader(strings.NewReader(input), opts)
+	var xs [][]float64
+	var labels []float64
+	for {
+		x, label, err := r.Read()
+		if err == io.EOF {
+			return xs, labels, nil
+		}
+		if err != nil {
+			return xs, labels, err
+		}
+		xs = append(xs, x)
+		labels = append(labels, label)
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	input := "a;b;c;d\n1;2;3;4\n5;;7;8\n9;10;NaN;12\n"
+	r := NewCSVReader(strings.NewReader(input), CSVOptions{Header: true, Comma: ';', LabelCol: 3, Columns: []int{2, 0}, Missing: MissingSkip})
+	x, label, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(x, []float64{3, 1}) || label != 4 || r.Line() != 2 {
+		t.Errorf("got %v label %v on line %d", x, label, r.Line())
+	}
+	if !reflect.DeepEqual(r.Header(), []string{"a", "b", "c", "d"}) {
+		t.Errorf("header %v", r.Header())
+	}
+	// Column 1 is missing in the next row but not selected.
+	x, label, err = r.Read()
+	if err != nil || !reflect.DeepEqual(x, []float64{7, 5}) || label != 8 {
+		t.Errorf("got %v label %v, %v", x, label, err)
+	}
+	if _, _, err := r.Read(); err != io.EOF {
+		t.Errorf("row with missing feature not skipped: %v", err)
+	}
+
+	xs, _, err := readAll(t, "1,,3\n", CSVOptions{LabelCol: -1, Missing: MissingFill, Fill: -1})
+	if err != nil || !reflect.DeepEqual(xs, [][]float64{{1, -1, 3}}) {
+		t.Errorf("fill: got %v, %v", xs, err)
+	}
+}
+
+func TestCSVReaderErrors(t *testing.T) {
+	cases := []struct {
+		input string
+		opts  CSVOptions
+		want  string
+	}{
+		{"1,2\n3,4\n5\n", CSVOptions{}, "line 3: 1 fields, want 2"},
+		{"1,2\n3,x\n", CSVOptions{}, "line 2 column 1"},
+		{"1,2\n3,\n", CSVOptions{}, "line 2 column 1: missing value"},
+		{"1,2\n", CSVOptions{LabelCol: 2}, "label column 2"},
+		{"1,2\n", CSVOptions{Columns: []int{5}}, "column 5"},
+	}
+	for _, c := range cases {
+		_, _, err := readAll(t, c.input, c.opts)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: got error %v, want %q", c.input, err, c.want)
+		}
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "m.csv")
+	os.WriteFile(fname, []byte("1,2,3\n4,5,6\n"), 0644)
+	m, err := LoadCSV(fname)
+	if err != nil || m.Rows != 2 || m.Cols != 3 || m.X[5] != 6 {
+		t.Errorf("got %+v, %v", m, err)
+	}
+	os.WriteFile(fname, []byte("1,2,3\n4,5\n"), 0644)
+	if _, err := LoadCSV(fname); err == nil {
+		t.Error("loaded a ragged file")
+	}
+}
@@ -0,0 +1,115 @@
+package lab
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Tile sizes for gemm. A blockRows x blockInner tile of the left matrix and a
+// blockInner x blockCols tile of the right one fit comfortably in L2.
+const (
+	blockRows  = 32
+	blockInner = 128
+	blockCols  = 256
+
+	// Products with fewer multiply-adds than this run on the calling
+	// goroutine, since spawning workers would cost more than it saves.
+	parallelThreshold = 1 << 15
+)
+
+var workers int64
+
+// SetWorkers sets how many goroutines Multiply spreads its work across. n <= 0
+// restores the default, which is GOMAXPROCS.
+func SetWorkers(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt64(&workers, int64(n))
+}
+
+// Workers returns the number of goroutines Multiply uses.
+func Workers() int {
+	if n := atomic.LoadInt64(&workers); n > 0 {
+		return int(n)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// gemm sets c to a * b. Row blocks of c are handed out to the workers, so no
+// two goroutines ever write the same part of c.
+func gemm(c, a, b *Matrix) {
+	for i := range c.X {
+		c.X[i] = 0
+	}
+	blocks := (a.Rows + blockRows - 1) / blockRows
+	n := Workers()
+	if n > blocks {
+		n = blocks
+	}
+	if n <= 1 || a.Rows*a.Cols*b.Cols < parallelThreshold {
+		gemmRows(c, a, b, 0, a.Rows)
+		return
+	}
+
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for w := 0; w < n; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				block := int(atomic.AddInt64(&next, 1))
+				if block >= blocks {
+					return
+				}
+				start := block * blockRows
+				gemmRows(c, a, b, start, minInt(start+blockRows, a.Rows))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// gemmRows adds rows [i0, i1) of a * b into c, walking b one tile at a time so
+// that its rows stay in cache while they are reused for every row of a.
+func gemmRows(c, a, b *Matrix, i0, i1 int) {
+	inner, cols := a.Cols, b.Cols
+	for k0 := 0; k0 < inner; k0 += blockInner {
+		k1 := minInt(k0+blockInner, inner)
+		for j0 := 0; j0 < cols; j0 += blockCols {
+			j1 := minInt(j0+blockCols, cols)
+			for i := i0; i < i1; i++ {
+				cRow := c.X[i*cols+j0 : i*cols+j1]
+				aRow := a.X[i*inner : (i+1)*inner]
+				for k := k0; k < k1; k++ {
+					aik := aRow[k]
+					bRow := b.X[k*cols+j0 : k*cols+j1]
+					for j, bkj := range bRow {
+						cRow[j] += aik * bkj
+					}
+				}
+			}
+		}
+	}
+}
+
+// multiplyNaive is the original dot-product-per-cell multiply, kept as a
+// reference for tests and benchmarks.
+func multiplyNaive(m, m1 *Matrix) *Matrix {
+	mout := NewMatrix(m.Rows, m1.Cols)
+	for i := 0; i < m.Rows; i++ {
+		for j := 0; j < m1.Cols; j++ {
+			mout.X[mout.Cols*i+j] = m.Row(i).Dot(m1.Col(j))
+		}
+	}
+	return mout
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,82 @@
+package lab
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestMultiplyMatchesNaive(t *testing.T) {
+	defer SetWorkers(0)
+	shapes := [][3]int{{1, 1, 1}, {3, 5, 2}, {100, 784, 10}, {70, 300, 513}, {33, 129, 257}}
+	for _, w := range []int{1, 3, 8} {
+		SetWorkers(w)
+		for _, s := range shapes {
+			a, b := Gaussian(s[0], s[1]), Gaussian(s[1], s[2])
+			want := multiplyNaive(a, b)
+			got := a.Multiply(b)
+			for i := range want.X {
+				if math.Abs(want.X[i]-got.X[i]) > 1e-9 {
+					t.Fatalf("workers %d shape %v: element %d is %v, want %v", w, s, i, got.X[i], want.X[i])
+				}
+			}
+		}
+	}
+}
+
+func TestMultiplyPropagatesNaN(t *testing.T) {
+	// 0 * Inf and 0 * NaN are NaN, so a diverged weight must poison the
+	// product even where the other factor is 0.
+	for _, bad := range []float64{math.Inf(1), math.NaN()} {
+		a := &Matrix{X: []float64{0, 1, 0, 2}, Rows: 2, Cols: 2}
+		b := &Matrix{X: []float64{bad, 1, 3, 4}, Rows: 2, Cols: 2}
+		want, got := multiplyNaive(a, b), a.Multiply(b)
+		for i := range want.X {
+			if w, g := want.X[i], got.X[i]; math.IsNaN(w) != math.IsNaN(g) || (!math.IsNaN(w) && w != g) {
+				t.Errorf("%v: element %d is %v, want %v", bad, i, g, w)
+			}
+		}
+		if !math.IsNaN(got.X[0]) {
+			t.Errorf("%v: 0*%v gave %v, want NaN", bad, bad, got.X[0])
+		}
+	}
+}
+
+// benchShapes are the products of an FCLayer forward and backward pass on the
+// MNIST model for a batch of 64.
+var benchShapes = [][3]int{
+	{100, 784, 64}, // W * x
+	{784, 100, 64}, // W^T * dy
+	{100, 64, 784}, // dy * x^T
+	{512, 512, 512},
+}
+
+func BenchmarkMultiplyNaive(b *testing.B) {
+	for _, s := range benchShapes {
+		x, y := Gaussian(s[0], s[1]), Gaussian(s[1], s[2])
+		b.Run(fmt.Sprintf("%dx%dx%d", s[0], s[1], s[2]), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				multiplyNaive(x, y)
+			}
+		})
+	}
+}
+
+func BenchmarkMultiply(b *testing.B) {
+	defer SetWorkers(0)
+	for _, w := range []string{"1", "max"} {
+		if w == "1" {
+			SetWorkers(1)
+		} else {
+			SetWorkers(0)
+		}
+		for _, s := range benchShapes {
+			x, y := Gaussian(s[0], s[1]), Gaussian(s[1], s[2])
+			b.Run(fmt.Sprintf("workers=%s/%dx%dx%d", w, s[0], s[1], s[2]), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					x.Multiply(y)
+				}
+			})
+		}
+	}
+}
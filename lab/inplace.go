@@ -0,0 +1,145 @@
+package lab
+
+import "math"
+
+// The functions in this file write their result into a destination matrix
+// instead of allocating one. Elementwise operations allow dst to be one of
+// their operands; matrix products do not.
+
+// Ensure returns a rows x cols matrix, reusing the storage of m when it is
+// large enough. The contents of the returned matrix are unspecified.
+func Ensure(m *Matrix, rows, cols int) *Matrix {
+	if m == nil || cap(m.X) < rows*cols {
+		return NewMatrix(rows, cols)
+	}
+	m.X = m.X[:rows*cols]
+	m.Rows = rows
+	m.Cols = cols
+	return m
+}
+
+func checkSame(m, m1 *Matrix) {
+	if m.Cols != m1.Cols || m.Rows != m1.Rows {
+		panic("bounds don't match")
+	}
+}
+
+// AddInto sets dst to a + b and returns dst.
+func AddInto(dst, a, b *Matrix) *Matrix {
+	checkSame(a, b)
+	checkSame(dst, a)
+	for i := range dst.X {
+		dst.X[i] = a.X[i] + b.X[i]
+	}
+	return dst
+}
+
+// SubInto sets dst to a - b and returns dst.
+func SubInto(dst, a, b *Matrix) *Matrix {
+	checkSame(a, b)
+	checkSame(dst, a)
+	for i := range dst.X {
+		dst.X[i] = a.X[i] - b.X[i]
+	}
+	return dst
+}
+
+// MultElemsInto sets dst to the elementwise product of a and b and returns dst.
+func MultElemsInto(dst, a, b *Matrix) *Matrix {
+	checkSame(a, b)
+	checkSame(dst, a)
+	for i := range dst.X {
+		dst.X[i] = a.X[i] * b.X[i]
+	}
+	return dst
+}
+
+// ScaleInto sets dst to x * a and returns dst.
+func ScaleInto(dst, a *Matrix, x float64) *Matrix {
+	checkSame(dst, a)
+	for i := range dst.X {
+		dst.X[i] = a.X[i] * x
+	}
+	return dst
+}
+
+// ExpInto sets dst to the elementwise exponential of a and returns dst.
+func ExpInto(dst, a *Matrix) *Matrix {
+	checkSame(dst, a)
+	for i := range dst.X {
+		dst.X[i] = math.Exp(a.X[i])
+	}
+	return dst
+}
+
+// AddColInto sets dst to a with the column vector v added to every column and
+// returns dst.
+func AddColInto(dst, a, v *Matrix) *Matrix {
+	checkSame(dst, a)
+	if v.Cols != 1 || v.Rows != a.Rows {
+		panic("bounds don't match")
+	}
+	for i := 0; i < a.Rows; i++ {
+		row := a.X[i*a.Cols : (i+1)*a.Cols]
+		out := dst.X[i*a.Cols : (i+1)*a.Cols]
+		for j, x := range row {
+			out[j] = x + v.X[i]
+		}
+	}
+	return dst
+}
+
+// TransposeInto sets dst to the transpose of a and returns dst. dst must not
+// be a.
+func TransposeInto(dst, a *Matrix) *Matrix {
+	if dst.Rows != a.Cols || dst.Cols != a.Rows {
+		panic("bounds don't match")
+	}
+	for i := 0; i < a.Rows; i++ {
+		for j := 0; j < a.Cols; j++ {
+			dst.X[j*a.Rows+i] = a.X[i*a.Cols+j]
+		}
+	}
+	return dst
+}
+
+// MultiplyInto sets dst to a * b and returns dst. dst must not be a or b.
+func MultiplyInto(dst, a, b *Matrix) *Matrix {
+	if a.Cols != b.Rows || dst.Rows != a.Rows || dst.Cols != b.Cols {
+		panic("fucc.go dude ur dimensions r fucced")
+	}
+	gemm(dst, a, b)
+	return dst
+}
+
+// AddScaled adds alpha * x to m in place and returns m.
+func (m *Matrix) AddScaled(alpha float64, x *Matrix) *Matrix {
+	checkSame(m, x)
+	for i, v := range x.X {
+		m.X[i] += alpha * v
+	}
+	return m
+}
+
+// ScaleInPlace multiplies every element of m by x and returns m.
+func (m *Matrix) ScaleInPlace(x float64) *Matrix {
+	for i := range m.X {
+		m.X[i] *= x
+	}
+	return m
+}
+
+// Zero sets every element of m to 0 and returns m.
+func (m *Matrix) Zero() *Matrix {
+	for i := range m.X {
+		m.X[i] = 0
+	}
+	return m
+}
+
+// CopyFrom copies the elements of a into m and returns m.
+func (m *Matrix) CopyFrom(a *Matrix) *Matrix {
+	checkSame(m, a)
+	copy(m.X, a.X)
+	return m
+}
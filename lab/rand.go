@@ -0,0 +1,35 @@
+package lab
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// GaussianRand returns a rows x cols matrix of standard normal values drawn
+// from rng.
+func GaussianRand(rows, cols int, rng *rand.Rand) *Matrix {
+	mat := NewMatrix(rows, cols)
+	for i := range mat.X {
+		mat.X[i] = rng.NormFloat64()
+	}
+	return mat
+}
+
+// DeriveSeed derives the seed of one component of an experiment, such as
+// "init" or "train data", from the experiment's seed. Different names give
+// unrelated seeds, so every component gets its own reproducible stream.
+func DeriveSeed(seed int64, name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	// splitmix64 finalizer over the seed and the name's hash
+	z := uint64(seed) + 0x9e3779b97f4a7c15*(h.Sum64()|1)
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return int64(z ^ z>>31)
+}
+
+// NewRand returns a generator for the component name of an experiment
+// seeded with seed, as with DeriveSeed.
+func NewRand(seed int64, name string) *rand.Rand {
+	return rand.New(rand.NewSource(DeriveSeed(seed, name)))
+}
@@ -0,0 +1,29 @@
+package lab
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestDeriveSeed(t *testing.T) {
+	seen := map[int64]string{}
+	for _, seed := range []int64{0, 1, 2} {
+		for _, name := range []string{"", "init", "data", "data "} {
+			s := DeriveSeed(seed, name)
+			if prev, ok := seen[s]; ok {
+				t.Errorf("seed %d %q collides with %s", seed, name, prev)
+			}
+			seen[s] = name
+			if DeriveSeed(seed, name) != s {
+				t.Errorf("seed %d %q isn't deterministic", seed, name)
+			}
+		}
+	}
+	a := GaussianRand(3, 4, NewRand(7, "x"))
+	b := GaussianRand(3, 4, rand.New(rand.NewSource(DeriveSeed(7, "x"))))
+	for i := range a.X {
+		if a.X[i] != b.X[i] {
+			t.Fatalf("value %d: %v and %v from the same seed", i, a.X[i], b.X[i])
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package lab
+
+import (
+	"fmt"
+	"math"
+)
+
+// Tensor is an n-dimensional array over a shared buffer. Element (i0, i1, ...)
+// is Data[Offset + i0*Strides[0] + i1*Strides[1] + ...], so views such as
+// slices, transposes and broadcasts share Data with the tensor they came
+// from. A freshly allocated tensor is contiguous and row-major.
+type Tensor struct {
+	Data    []float64
+	Shape   []int
+	Strides []int
+	Offset  int
+}
+
+// rowMajor returns the strides of a contiguous tensor of the given shape.
+func rowMajor(shape []int) []int {
+	strides := make([]int, len(shape))
+	s := 1
+	for i := len(shape) - 1; i >= 0; i-- {
+		strides[i] = s
+		s *= shape[i]
+	}
+	return strides
+}
+
+func shapeSize(shape []int) int {
+	n := 1
+	for _, d := range shape {
+		if d < 0 {
+			panic(fmt.Sprintf("lab: negative dimension in shape %v", shape))
+		}
+		n *= d
+	}
+	return n
+}
+
+func NewTensor(shape ...int) *Tensor {
+	return &Tensor{
+		Data:    make([]float64, shapeSize(shape)),
+		Shape:   append([]int{}, shape...),
+		Strides: rowMajor(shape),
+	}
+}
+
+// TensorFrom wraps data, without copying it, as a tensor of the given shape.
+func TensorFrom(data []float64, shape ...int) *Tensor {
+	if shapeSize(shape) != len(data) {
+		panic(fmt.Sprintf("lab: %d values don't fill shape %v", len(data), shape))
+	}
+	return &Tensor{Data: data, Shape: append([]int{}, shape...), Strides: rowMajor(shape)}
+}
+
+// Tensor returns a Rows x Cols view of m.
+func (m *Matrix) Tensor() *Tensor {
+	return TensorFrom(m.X, m.Rows, m.Cols)
+}
+
+// Matrix returns t as a matrix. A 2-D contiguous tensor shares its data with
+// the matrix; other tensors are copied. 1-D tensors become columns.
+func (t *Tensor) Matrix() *Matrix {
+	switch len(t.Shape) {
+	case 1:
+		return t.Reshape(t.Shape[0], 1).Matrix()
+	case 2:
+	default:
+		panic(fmt.Sprintf("lab: can't make a matrix of a tensor of shape %v", t.Shape))
+	}
+	c := t
+	if !t.Contiguous() {
+		c = t.Copy()
+	}
+	return &Matrix{X: c.Data[c.Offset : c.Offset+c.Size()], Rows: c.Shape[0], Cols: c.Shape[1]}
+}
+
+func (t *Tensor) Dims() int {
+	return len(t.Shape)
+}
+
+func (t *Tensor) Size() int {
+	return shapeSize(t.Shape)
+}
+
+// Contiguous reports whether the elements of t are laid out row-major without
+// gaps, so that Data[Offset:Offset+Size()] holds them in order.
+func (t *Tensor) Contiguous() bool {
+	s := 1
+	for i := len(t.Shape) - 1; i >= 0; i-- {
+		if t.Shape[i] != 1 && t.Strides[i] != s {
+			return false
+		}
+		s *= t.Shape[i]
+	}
+	return true
+}
+
+func (t *Tensor) index(idx []int) int {
+	if len(idx) != len(t.Shape) {
+		panic(fmt.Sprintf("lab: %d indices for a tensor of shape %v", len(idx), t.Shape))
+	}
+	off := t.Offset
+	for i, x := range idx {
+		if x < 0 || x >= t.Shape[i] {
+			panic(fmt.Sprintf("lab: index %v out of range for shape %v", idx, t.Shape))
+		}
+		off += x * t.Strides[i]
+	}
+	return off
+}
+
+func (t *Tensor) At(idx ...int) float64 {
+	return t.Data[t.index(idx)]
+}
+
+func (t *Tensor) Set(v float64, idx ...int) {
+	t.Data[t.index(idx)] = v
+}
+
+// each calls f with the offsets into each tensor's Data of every element of
+// shape, in row-major order. The tensors must have shape or be broadcast to
+// it.
+func each(shape []int, ts []*Tensor, f func(offs []int)) {
+	if shapeSize(shape) == 0 {
+		return
+	}
+	offs := make([]int, len(ts))
+	for k, t := range ts {
+		offs[k] = t.Offset
+	}
+	idx := make([]int, len(shape))
+	for {
+		f(offs)
+		d := len(shape) - 1
+		for ; d >= 0; d-- {
+			idx[d]++
+			for k, t := range ts {
+				offs[k] += t.Strides[d]
+			}
+			if idx[d] < shape[d] {
+				break
+			}
+			for k, t := range ts {
+				offs[k] -= idx[d] * t.Strides[d]
+			}
+			idx[d] = 0
+		}
+		if d < 0 {
+			return
+		}
+	}
+}
+
+// Copy returns a contiguous copy of t.
+func (t *Tensor) Copy() *Tensor {
+	c := NewTensor(t.Shape...)
+	i := 0
+	each(t.Shape, []*Tensor{t}, func(offs []int) {
+		c.Data[i] = t.Data[offs[0]]
+		i++
+	})
+	return c
+}
+
+// Reshape returns t with a new shape of the same size. One dimension may be
+// -1, in which case it is inferred. The result is a view if t is contiguous
+// and a copy otherwise.
+func (t *Tensor) Reshape(shape ...int) *Tensor {
+	shape = append([]int{}, shape...)
+	infer, known := -1, 1
+	for i, d := range shape {
+		if d == -1 && infer < 0 {
+			infer = i
+		} else {
+			known *= d
+		}
+	}
+	if infer >= 0 && known > 0 {
+		shape[infer] = t.Size() / known
+	}
+	if shapeSize(shape) != t.Size() {
+		panic(fmt.Sprintf("lab: can't reshape %v to %v", t.Shape, shape))
+	}
+	c := t
+	if !t.Contiguous() {
+		c = t.Copy()
+	}
+	return &Tensor{Data: c.Data, Shape: shape, Strides: rowMajor(shape), Offset: c.Offset}
+}
+
+// Transpose returns a view of t with its axes permuted: axis i of the result
+// is axis axes[i] of t. With no axes the order is reversed.
+func (t *Tensor) Transpose(axes ...int) *Tensor {
+	n := len(t.Shape)
+	if len(axes) == 0 {
+		for i := n - 1; i >= 0; i-- {
+			axes = append(axes, i)
+		}
+	}
+	if len(axes) != n {
+		panic(fmt.Sprintf("lab: permutation %v for a tensor of shape %v", axes, t.Shape))
+	}
+	r := &Tensor{Data: t.Data, Shape: make([]int, n), Strides: make([]int, n), Offset: t.Offset}
+	seen := make([]bool, n)
+	for i, a := range axes {
+		if a < 0 || a >= n || seen[a] {
+			panic(fmt.Sprintf("lab: %v is not a permutation of %d axes", axes, n))
+		}
+		seen[a] = true
+		r.Shape[i], r.Strides[i] = t.Shape[a], t.Strides[a]
+	}
+	return r
+}
+
+// Slice returns a view of elements start to end-1 along axis.
+func (t *Tensor) Slice(axis, start, end int) *Tensor {
+	if start < 0 || end > t.Shape[axis] || start > end {
+		panic(fmt.Sprintf("lab: slice [%d:%d] out of range for axis %d of shape %v", start, end, axis, t.Shape))
+	}
+	r := &Tensor{
+		Data:    t.Data,
+		Shape:   append([]int{}, t.Shape...),
+		Strides: append([]int{}, t.Strides...),
+		Offset:  t.Offset + start*t.Strides[axis],
+	}
+	r.Shape[axis] = end - start
+	return r
+}
+
+// Index returns a view of element i along axis, with that axis removed.
+func (t *Tensor) Index(axis, i int) *Tensor {
+	s := t.Slice(axis, i, i+1)
+	s.Shape = append(s.Shape[:axis], s.Shape[axis+1:]...)
+	s.Strides = append(s.Strides[:axis], s.Strides[axis+1:]...)
+	return s
+}
+
+// BroadcastShapes returns the shape that tensors of shapes a and b broadcast
+// to, numpy style: shapes are aligned at their last axis and dimensions must
+// match or be 1.
+func BroadcastShapes(a, b []int) ([]int, error) {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	shape := append([]int{}, a...)
+	for i := 1; i <= len(b); i++ {
+		da, db := a[len(a)-i], b[len(b)-i]
+		switch {
+		case da == db || db == 1:
+		case da == 1:
+			shape[len(a)-i] = db
+		default:
+			return nil, fmt.Errorf("lab: shapes %v and %v don't broadcast", a, b)
+		}
+	}
+	return shape, nil
+}
+
+// Broadcast returns a view of t repeated to shape, using zero strides for the
+// repeated axes.
+func (t *Tensor) Broadcast(shape ...int) *Tensor {
+	if len(shape) < len(t.Shape) {
+		panic(fmt.Sprintf("lab: can't broadcast %v to %v", t.Shape, shape))
+	}
+	r := &Tensor{Data: t.Data, Shape: append([]int{}, shape...), Strides: make([]int, len(shape)), Offset: t.Offset}
+	lead := len(shape) - len(t.Shape)
+	for i, d := range t.Shape {
+		switch {
+		case d == shape[lead+i]:
+			r.Strides[lead+i] = t.Strides[i]
+		case d == 1:
+		default:
+			panic(fmt.Sprintf("lab: can't broadcast %v to %v", t.Shape, shape))
+		}
+	}
+	return r
+}
+
+// Zip applies f elementwise to t and u, broadcast to a common shape, and
+// returns the result as a new tensor.
+func (t *Tensor) Zip(u *Tensor, f func(a, b float64) float64) *Tensor {
+	shape, err := BroadcastShapes(t.Shape, u.Shape)
+	if err != nil {
+		panic(err)
+	}
+	a, b := t.Broadcast(shape...), u.Broadcast(shape...)
+	r := NewTensor(shape...)
+	i := 0
+	each(shape, []*Tensor{a, b}, func(offs []int) {
+		r.Data[i] = f(a.Data[offs[0]], b.Data[offs[1]])
+		i++
+	})
+	return r
+}
+
+// Apply returns a new tensor of f applied to every element of t.
+func (t *Tensor) Apply(f func(float64) float64) *Tensor {
+	r := NewTensor(t.Shape...)
+	i := 0
+	each(t.Shape, []*Tensor{t}, func(offs []int) {
+		r.Data[i] = f(t.Data[offs[0]])
+		i++
+	})
+	return r
+}
+
+func (t *Tensor) Add(u *Tensor) *Tensor {
+	return t.Zip(u, func(a, b float64) float64 { return a + b })
+}
+
+func (t *Tensor) Sub(u *Tensor) *Tensor {
+	return t.Zip(u, func(a, b float64) float64 { return a - b })
+}
+
+func (t *Tensor) Mul(u *Tensor) *Tensor {
+	return t.Zip(u, func(a, b float64) float64 { return a * b })
+}
+
+func (t *Tensor) Div(u *Tensor) *Tensor {
+	return t.Zip(u, func(a, b float64) float64 { return a / b })
+}
+
+func (t *Tensor) Scale(x float64) *Tensor {
+	return t.Apply(func(a float64) float64 { return a * x })
+}
+
+// reduce folds the elements along axis with f, starting from init, and keeps
+// the axis with size 1 so the result broadcasts against t.
+func (t *Tensor) reduce(axis int, init float64, f func(acc, x float64) float64) *Tensor {
+	if axis < 0 || axis >= len(t.Shape) {
+		panic(fmt.Sprintf("lab: axis %d out of range for shape %v", axis, t.Shape))
+	}
+	shape := append([]int{}, t.Shape...)
+	shape[axis] = 1
+	r := NewTensor(shape...)
+	for i := range r.Data {
+		r.Data[i] = init
+	}
+	// Walk t with the result's axis stride zeroed so every element along
+	// axis lands on the same output.
+	acc := &Tensor{Data: r.Data, Shape: t.Shape, Strides: append([]int{}, r.Strides...)}
+	acc.Strides[axis] = 0
+	each(t.Shape, []*Tensor{t, acc}, func(offs []int) {
+		r.Data[offs[1]] = f(r.Data[offs[1]], t.Data[offs[0]])
+	})
+	return r
+}
+
+// Sum adds up the elements along axis, which is kept with size 1.
+func (t *Tensor) Sum(axis int) *Tensor {
+	return t.reduce(axis, 0, func(acc, x float64) float64 { return acc + x })
+}
+
+// Mean averages the elements along axis, which is kept with size 1.
+func (t *Tensor) Mean(axis int) *Tensor {
+	return t.Sum(axis).Scale(1 / float64(t.Shape[axis]))
+}
+
+// Max takes the largest element along axis, which is kept with size 1.
+func (t *Tensor) Max(axis int) *Tensor {
+	return t.reduce(axis, math.Inf(-1), math.Max)
+}
+
+// Min takes the smallest element along axis, which is kept with size 1.
+func (t *Tensor) Min(axis int) *Tensor {
+	return t.reduce(axis, math.Inf(1), math.Min)
+}
+
+// Squeeze returns a view of t without axis, which must have size 1.
+func (t *Tensor) Squeeze(axis int) *Tensor {
+	if t.Shape[axis] != 1 {
+		panic(fmt.Sprintf("lab: can't squeeze axis %d of shape %v", axis, t.Shape))
+	}
+	return t.Index(axis, 0)
+}
+
+// Total adds up every element of t.
+func (t *Tensor) Total() float64 {
+	var sum float64
+	each(t.Shape, []*Tensor{t}, func(offs []int) {
+		sum += t.Data[offs[0]]
+	})
+	return sum
+}
+
+func (t *Tensor) String() string {
+	return fmt.Sprintf("Tensor%v%v", t.Shape, t.Copy().Data)
+}
@@ -0,0 +1,96 @@
+package lab
+
+import (
+	"reflect"
+	"testing"
+)
+
+func seq(n int) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = float64(i)
+	}
+	return x
+}
+
+func TestTensorViews(t *testing.T) {
+	a := TensorFrom(seq(24), 2, 3, 4)
+	if a.At(1, 2, 3) != 23 || a.At(0, 1, 0) != 4 {
+		t.Fatalf("At: got %v, %v", a.At(1, 2, 3), a.At(0, 1, 0))
+	}
+
+	tr := a.Transpose(2, 0, 1)
+	if !reflect.DeepEqual(tr.Shape, []int{4, 2, 3}) || tr.At(3, 1, 2) != 23 || tr.Contiguous() {
+		t.Errorf("transpose: shape %v, At %v", tr.Shape, tr.At(3, 1, 2))
+	}
+	// Views share data.
+	tr.Set(-1, 0, 0, 1)
+	if a.At(0, 1, 0) != -1 {
+		t.Error("transpose is not a view")
+	}
+	a.Set(4, 0, 1, 0)
+
+	s := a.Slice(2, 1, 3).Index(0, 1)
+	if got := s.Copy().Data; !reflect.DeepEqual(got, []float64{13, 14, 17, 18, 21, 22}) {
+		t.Errorf("slice: got %v", got)
+	}
+	if r := s.Reshape(-1); !reflect.DeepEqual(r.Data, []float64{13, 14, 17, 18, 21, 22}) {
+		t.Errorf("reshape of a strided view: got %v", r.Data)
+	}
+	if r := a.Reshape(4, -1); r.Shape[1] != 6 || &r.Data[0] != &a.Data[0] {
+		t.Errorf("reshape of contiguous tensor: shape %v, shares data %v", r.Shape, &r.Data[0] == &a.Data[0])
+	}
+}
+
+func TestTensorBroadcast(t *testing.T) {
+	a := TensorFrom(seq(6), 2, 3)
+	col := TensorFrom([]float64{10, 20}, 2, 1)
+	row := TensorFrom([]float64{1, 2, 3}, 3)
+	if got := a.Add(col).Data; !reflect.DeepEqual(got, []float64{10, 11, 12, 23, 24, 25}) {
+		t.Errorf("a + col = %v", got)
+	}
+	if got := a.Mul(row).Data; !reflect.DeepEqual(got, []float64{0, 2, 6, 3, 8, 15}) {
+		t.Errorf("a * row = %v", got)
+	}
+	if got := col.Sub(row); !reflect.DeepEqual(got.Shape, []int{2, 3}) || got.At(1, 2) != 17 {
+		t.Errorf("col - row = %v", got)
+	}
+	if _, err := BroadcastShapes([]int{2, 3}, []int{2}); err == nil {
+		t.Error("broadcast 2x3 with 2")
+	}
+}
+
+func TestTensorReductions(t *testing.T) {
+	a := TensorFrom(seq(24), 2, 3, 4)
+	if got := a.Sum(1); !reflect.DeepEqual(got.Shape, []int{2, 1, 4}) || !reflect.DeepEqual(got.Data, []float64{12, 15, 18, 21, 48, 51, 54, 57}) {
+		t.Errorf("sum over axis 1 = %v", got)
+	}
+	if got := a.Max(2).Squeeze(2).Copy().Data; !reflect.DeepEqual(got, []float64{3, 7, 11, 15, 19, 23}) {
+		t.Errorf("max over axis 2 = %v", got)
+	}
+	if got := a.Transpose().Mean(2).Data; !reflect.DeepEqual(got, []float64{6, 10, 14, 7, 11, 15, 8, 12, 16, 9, 13, 17}) {
+		t.Errorf("mean over transposed axis 2 = %v", got)
+	}
+	if a.Total() != 276 {
+		t.Errorf("total = %v", a.Total())
+	}
+	// Subtracting the max broadcasts against the kept axis.
+	if got := a.Sub(a.Max(2)).Max(2).Total(); got != 0 {
+		t.Errorf("max of a - max(a) = %v", got)
+	}
+}
+
+func TestTensorMatrix(t *testing.T) {
+	m := &Matrix{X: seq(6), Rows: 2, Cols: 3}
+	if back := m.Tensor().Matrix(); &back.X[0] != &m.X[0] || back.Rows != 2 {
+		t.Error("contiguous round trip copied")
+	}
+	mt := m.Tensor().Transpose().Matrix()
+	want := m.Transpose()
+	if mt.Rows != want.Rows || !reflect.DeepEqual(mt.X, want.X) {
+		t.Errorf("transposed tensor as matrix = %v, want %v", mt, want)
+	}
+	if img := m.Tensor().Reshape(3, 2).Matrix(); img.Rows != 3 || m.Rows != 2 {
+		t.Error("reshape changed the matrix")
+	}
+}
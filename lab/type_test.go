@@ -2,6 +2,7 @@ package lab
 
 import (
 	"fmt"
+	"math"
 	"testing"
 )
 
@@ -29,3 +30,29 @@ func TestIdMatrix(t *testing.T) {
 	fmt.Println(newMat.Multiply(vec))
 	fmt.Println(vec2.Multiply(vec))
 }
+
+func TestInPlace(t *testing.T) {
+	a := NewVector([]float64{1, 2, 3, 4}).Row()
+	b := NewVector([]float64{4, 3, 2, 1}).Row()
+	AddInto(a, a, b)
+	a.AddScaled(-2, b).ScaleInPlace(.5)
+	want := []float64{-1.5, -.5, .5, 1.5}
+	for i, x := range a.X {
+		if x != want[i] {
+			t.Fatalf("got %v, want %v", a.X, want)
+		}
+	}
+	e := NewVector([]float64{0, 1}).Row().Exp()
+	if e.X[0] != 1 || e.X[1] != math.E {
+		t.Errorf("Exp: got %v", e.X)
+	}
+
+	buf := NewMatrix(4, 4)
+	small := Ensure(buf, 2, 3)
+	if small.Rows != 2 || small.Cols != 3 || len(small.X) != 6 || &small.X[0] != &buf.X[0] {
+		t.Errorf("Ensure didn't reuse storage for a smaller matrix")
+	}
+	if big := Ensure(small, 5, 5); len(big.X) != 25 {
+		t.Errorf("Ensure: got %d elements, want 25", len(big.X))
+	}
+}
@@ -1,16 +1,13 @@
 package lab
 
 import (
-	"encoding/csv"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
 	"io"
-	"math"
 	"math/rand"
 	"os"
-	"strconv"
 	"strings"
 )
 
@@ -21,11 +18,7 @@ type Matrix struct {
 }
 
 func (m *Matrix) Exp() *Matrix {
-	r := NewMatrix(m.Rows, m.Cols)
-	for i, x := range r.X {
-		r.X[i] = math.Exp(x)
-	}
-	return r
+	return ExpInto(NewMatrix(m.Rows, m.Cols), m)
 }
 
 func VStack(matrices ...*Matrix) *Matrix {
@@ -92,39 +85,30 @@ func (m *Matrix) ImWriteBW(fname string) error {
 
 }
 
+// LoadCSV reads a file of numeric rows, without a header, into a matrix with
+// one row per line.
 func LoadCSV(fileName string) (*Matrix, error) {
-	var buffer []float64
-	var rows int
 	file, err := os.Open(fileName)
-	defer file.Close()
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	r := csv.NewReader(file)
-	var line []float64
-
+	r := NewCSVReader(file, CSVOptions{LabelCol: -1})
+	var buffer []float64
+	var rows, cols int
 	for {
-		record, err := r.Read()
+		line, _, err := r.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
-		}
-		if rows == 0 {
-			line = make([]float64, len(record))
+			return nil, fmt.Errorf("%s: %v", fileName, err)
 		}
 		rows++
-		for i, val := range record {
-			line[i], err = strconv.ParseFloat(val, 64)
-			if err != nil {
-				return nil, err
-			}
-		}
+		cols = len(line)
 		buffer = append(buffer, line...)
 	}
-	cols := len(buffer) / rows
 	return &Matrix{
 		X:    buffer,
 		Rows: rows,
@@ -137,34 +121,34 @@ func (m *Matrix) Multiply(m1 *Matrix) *Matrix {
 		panic("fucc.go dude ur dimensions r fucced")
 	}
 	mout := NewMatrix(m.Rows, m1.Cols)
-	for i := 0; i < m.Rows; i++ {
-		for j := 0; j < m1.Cols; j++ {
-			mout.X[mout.Cols*i+j] = m.Row(i).Dot(m1.Col(j))
-		}
-	}
+	gemm(mout, m, m1)
 	return mout
 }
 
 func (m *Matrix) Add(m1 *Matrix) *Matrix {
-	if m.Cols != m1.Cols || m.Rows != m1.Rows {
-		panic("bounds don't match")
-	}
-	ret := NewMatrix(m.Rows, m.Cols)
-	for i := range m.X {
-		ret.X[i] = m.X[i] + m1.X[i]
+	return AddInto(NewMatrix(m.Rows, m.Cols), m, m1)
+}
+
+// AddCol adds the column vector v to every column of m.
+func (m *Matrix) AddCol(v *Matrix) *Matrix {
+	return AddColInto(NewMatrix(m.Rows, m.Cols), m, v)
+}
+
+// SumCols adds up the columns of m into a single column vector.
+func (m *Matrix) SumCols() *Matrix {
+	ret := NewMatrix(m.Rows, 1)
+	for i := 0; i < m.Rows; i++ {
+		var sum float64
+		for _, x := range m.X[i*m.Cols : (i+1)*m.Cols] {
+			sum += x
+		}
+		ret.X[i] = sum
 	}
 	return ret
 }
 
 func (m *Matrix) MultElems(m1 *Matrix) *Matrix {
-	if m.Cols != m1.Cols || m.Rows != m1.Rows {
-		panic("bounds don't match")
-	}
-	ret := NewMatrix(m.Rows, m.Cols)
-	for i := range m.X {
-		ret.X[i] = m.X[i] * m1.X[i]
-	}
-	return ret
+	return MultElemsInto(NewMatrix(m.Rows, m.Cols), m, m1)
 }
 
 func (m *Matrix) SubMatrix(i, j, rows, columns int) *Matrix {
@@ -180,22 +164,11 @@ func (m *Matrix) SubMatrix(i, j, rows, columns int) *Matrix {
 }
 
 func (m *Matrix) Sub(m1 *Matrix) *Matrix {
-	if m.Cols != m1.Cols || m.Rows != m1.Rows {
-		panic("bounds don't match")
-	}
-	ret := NewMatrix(m.Rows, m.Cols)
-	for i := range m.X {
-		ret.X[i] = m.X[i] - m1.X[i]
-	}
-	return ret
+	return SubInto(NewMatrix(m.Rows, m.Cols), m, m1)
 }
 
 func (m *Matrix) Scale(x float64) *Matrix {
-	ret := NewMatrix(m.Rows, m.Cols)
-	for i := range m.X {
-		ret.X[i] = m.X[i] * x
-	}
-	return ret
+	return ScaleInto(NewMatrix(m.Rows, m.Cols), m, x)
 }
 
 func (m *Matrix) Access(i, j int) float64 {
@@ -236,6 +209,8 @@ func NewMatrix(rows, cols int) *Matrix {
 	}
 }
 
+// Gaussian returns a matrix of standard normal values from math/rand's global
+// source. Use GaussianRand for draws that don't depend on global state.
 func Gaussian(rows, cols int) *Matrix {
 	mat := NewMatrix(rows, cols)
 	for i := range mat.X {
@@ -326,11 +301,5 @@ func (m *Matrix) String() string {
 }
 
 func (m *Matrix) Transpose() *Matrix {
-	mat := NewMatrix(m.Cols, m.Rows)
-	for i := 0; i < m.Rows; i++ {
-		for j := 0; j < m.Cols; j++ {
-			mat.X[j*m.Rows+i] = m.X[i*m.Cols+j]
-		}
-	}
-	return mat
+	return TransposeInto(NewMatrix(m.Cols, m.Rows), m)
 }
@@ -0,0 +1,345 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+)
+
+// elementwise is the shared base of the activation layers. forward applies f
+// to every input and backward scales the incoming gradient by df, the
+// derivative of f given the input x and output y of the last Forward.
+type elementwise struct {
+	Input      *lab.Matrix `json:"-"`
+	Activation *lab.Matrix `json:"-"`
+}
+
+func (e *elementwise) forward(matrix *lab.Matrix, f func(x float64) float64) *lab.Matrix {
+	ret := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	for i, x := range matrix.X {
+		ret.X[i] = f(x)
+	}
+	e.Input, e.Activation = matrix, ret
+	return ret
+}
+
+func (e *elementwise) backward(matrix *lab.Matrix, df func(x, y float64) float64) *lab.Matrix {
+	ret := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	for i, g := range matrix.X {
+		ret.X[i] = g * df(e.Input.X[i], e.Activation.X[i])
+	}
+	return ret
+}
+
+func (e *elementwise) Update(rate float64) {
+}
+
+type TanhActivation struct {
+	elementwise
+}
+
+func (f *TanhActivation) Forward(matrix *lab.Matrix) *lab.Matrix {
+	return f.forward(matrix, math.Tanh)
+}
+
+func (f *TanhActivation) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return f.backward(matrix, func(_, y float64) float64 { return 1 - y*y })
+}
+
+type Sigmoid struct {
+	elementwise
+}
+
+func (f *Sigmoid) Forward(matrix *lab.Matrix) *lab.Matrix {
+	return f.forward(matrix, sigmoid)
+}
+
+func (f *Sigmoid) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return f.backward(matrix, func(_, y float64) float64 { return y * (1 - y) })
+}
+
+// RELU is max(x, 0).
+type RELU struct {
+	elementwise
+}
+
+func (f *RELU) Forward(matrix *lab.Matrix) *lab.Matrix {
+	return f.forward(matrix, func(x float64) float64 { return math.Max(x, 0) })
+}
+
+func (f *RELU) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return f.backward(matrix, step)
+}
+
+func step(x, _ float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+// LeakyReLU is x for positive x and Slope*x otherwise. A zero Slope means .01.
+type LeakyReLU struct {
+	Slope float64
+	elementwise
+}
+
+func (f *LeakyReLU) slope() float64 {
+	if f.Slope == 0 {
+		return .01
+	}
+	return f.Slope
+}
+
+func (f *LeakyReLU) Forward(matrix *lab.Matrix) *lab.Matrix {
+	a := f.slope()
+	return f.forward(matrix, func(x float64) float64 {
+		if x > 0 {
+			return x
+		}
+		return a * x
+	})
+}
+
+func (f *LeakyReLU) Backward(matrix *lab.Matrix) *lab.Matrix {
+	a := f.slope()
+	return f.backward(matrix, func(x, _ float64) float64 {
+		if x > 0 {
+			return 1
+		}
+		return a
+	})
+}
+
+// PReLU is a leaky ReLU whose negative slopes are learned, one per each of
+// Features equal blocks of rows: a slope per feature of a flat input, or per
+// channel of an image stored channel by channel.
+type PReLU struct {
+	Features int
+	Alpha    *lab.Matrix
+
+	dAlpha *lab.Matrix
+	elementwise
+}
+
+// NewPReLU returns a PReLU with every slope starting at .25.
+func NewPReLU(features int) *PReLU {
+	return &PReLU{Features: features, Alpha: lab.Solid(features, 1, .25)}
+}
+
+func (f *PReLU) Params() []*Param {
+	if f.dAlpha == nil {
+		f.dAlpha = lab.NewMatrix(f.Features, 1)
+	}
+	return []*Param{{Name: "Alpha", Value: f.Alpha, Grad: f.dAlpha}}
+}
+
+func (f *PReLU) Forward(matrix *lab.Matrix) *lab.Matrix {
+	if matrix.Rows%f.Features != 0 {
+		panic(fmt.Sprintf("nn: PReLU with %d features got %d rows", f.Features, matrix.Rows))
+	}
+	ret := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	block := len(matrix.X) / f.Features
+	for i, x := range matrix.X {
+		if x > 0 {
+			ret.X[i] = x
+		} else {
+			ret.X[i] = f.Alpha.X[i/block] * x
+		}
+	}
+	f.Input, f.Activation = matrix, ret
+	return ret
+}
+
+func (f *PReLU) Backward(matrix *lab.Matrix) *lab.Matrix {
+	f.Params()
+	ret := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	block := len(matrix.X) / f.Features
+	for i, g := range matrix.X {
+		if x := f.Input.X[i]; x > 0 {
+			ret.X[i] = g
+		} else {
+			ret.X[i] = f.Alpha.X[i/block] * g
+			f.dAlpha.X[i/block] += g * x
+		}
+	}
+	return ret
+}
+
+// Update takes a plain gradient step and clears the gradients.
+func (f *PReLU) Update(rate float64) {
+	sgdUpdate(f, rate)
+}
+
+// ELU is x for positive x and Alpha*(e^x - 1) otherwise. A zero Alpha means 1.
+type ELU struct {
+	Alpha float64
+	elementwise
+}
+
+func (f *ELU) alpha() float64 {
+	if f.Alpha == 0 {
+		return 1
+	}
+	return f.Alpha
+}
+
+func (f *ELU) Forward(matrix *lab.Matrix) *lab.Matrix {
+	a := f.alpha()
+	return f.forward(matrix, func(x float64) float64 { return elu(x, a) })
+}
+
+func (f *ELU) Backward(matrix *lab.Matrix) *lab.Matrix {
+	a := f.alpha()
+	return f.backward(matrix, func(x, _ float64) float64 { return eluGrad(x, a) })
+}
+
+func elu(x, alpha float64) float64 {
+	if x > 0 {
+		return x
+	}
+	return alpha * math.Expm1(x)
+}
+
+func eluGrad(x, alpha float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return alpha * math.Exp(x)
+}
+
+// The SELU constants, chosen so activations keep zero mean and unit variance.
+const (
+	seluAlpha = 1.6732632423543772848170429916717
+	seluScale = 1.0507009873554804934193349852946
+)
+
+// SELU is the self-normalizing scaled ELU.
+type SELU struct {
+	elementwise
+}
+
+func (f *SELU) Forward(matrix *lab.Matrix) *lab.Matrix {
+	return f.forward(matrix, func(x float64) float64 { return seluScale * elu(x, seluAlpha) })
+}
+
+func (f *SELU) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return f.backward(matrix, func(x, _ float64) float64 { return seluScale * eluGrad(x, seluAlpha) })
+}
+
+// GELU is x*Phi(x), with Phi the standard normal CDF.
+type GELU struct {
+	elementwise
+}
+
+func (f *GELU) Forward(matrix *lab.Matrix) *lab.Matrix {
+	return f.forward(matrix, func(x float64) float64 { return x * normalCDF(x) })
+}
+
+func (f *GELU) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return f.backward(matrix, func(x, _ float64) float64 {
+		return normalCDF(x) + x*math.Exp(-x*x/2)/math.Sqrt(2*math.Pi)
+	})
+}
+
+func normalCDF(x float64) float64 {
+	return .5 * math.Erfc(-x/math.Sqrt2)
+}
+
+// Swish is x*sigmoid(Beta*x). A zero Beta means 1, which is SiLU.
+type Swish struct {
+	Beta float64
+	elementwise
+}
+
+func (f *Swish) beta() float64 {
+	if f.Beta == 0 {
+		return 1
+	}
+	return f.Beta
+}
+
+func (f *Swish) Forward(matrix *lab.Matrix) *lab.Matrix {
+	b := f.beta()
+	return f.forward(matrix, func(x float64) float64 { return x * sigmoid(b*x) })
+}
+
+func (f *Swish) Backward(matrix *lab.Matrix) *lab.Matrix {
+	b := f.beta()
+	return f.backward(matrix, func(x, y float64) float64 {
+		s := sigmoid(b * x)
+		return s + b*y*(1-s)
+	})
+}
+
+// Softplus is log(1 + e^(Beta*x))/Beta, a smooth RELU. A zero Beta means 1.
+type Softplus struct {
+	Beta float64
+	elementwise
+}
+
+func (f *Softplus) beta() float64 {
+	if f.Beta == 0 {
+		return 1
+	}
+	return f.Beta
+}
+
+func (f *Softplus) Forward(matrix *lab.Matrix) *lab.Matrix {
+	b := f.beta()
+	return f.forward(matrix, func(x float64) float64 { return softplus(b*x) / b })
+}
+
+func (f *Softplus) Backward(matrix *lab.Matrix) *lab.Matrix {
+	b := f.beta()
+	return f.backward(matrix, func(x, _ float64) float64 { return sigmoid(b * x) })
+}
+
+// softplus computes log(1 + e^x) without overflow.
+func softplus(x float64) float64 {
+	return math.Max(x, 0) + math.Log1p(math.Exp(-math.Abs(x)))
+}
+
+// Mish is x*tanh(softplus(x)).
+type Mish struct {
+	elementwise
+}
+
+func (f *Mish) Forward(matrix *lab.Matrix) *lab.Matrix {
+	return f.forward(matrix, func(x float64) float64 { return x * math.Tanh(softplus(x)) })
+}
+
+func (f *Mish) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return f.backward(matrix, func(x, _ float64) float64 {
+		t := math.Tanh(softplus(x))
+		return t + x*(1-t*t)*sigmoid(x)
+	})
+}
+
+// HardTanh clamps its input to [Min, Max], or [-1, 1] if both are zero.
+type HardTanh struct {
+	Min, Max float64
+	elementwise
+}
+
+func (f *HardTanh) bounds() (float64, float64) {
+	if f.Min == 0 && f.Max == 0 {
+		return -1, 1
+	}
+	return f.Min, f.Max
+}
+
+func (f *HardTanh) Forward(matrix *lab.Matrix) *lab.Matrix {
+	lo, hi := f.bounds()
+	return f.forward(matrix, func(x float64) float64 { return math.Min(math.Max(x, lo), hi) })
+}
+
+func (f *HardTanh) Backward(matrix *lab.Matrix) *lab.Matrix {
+	lo, hi := f.bounds()
+	return f.backward(matrix, func(x, _ float64) float64 {
+		if x > lo && x < hi {
+			return 1
+		}
+		return 0
+	})
+}
@@ -0,0 +1,57 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"testing"
+)
+
+func TestActivationValues(t *testing.T) {
+	x := lab.NewVector([]float64{-1000, -1, 0, 1, 1000}).Col()
+	cases := []struct {
+		name  string
+		layer Layer
+		want  []float64
+	}{
+		{"RELU", &RELU{}, []float64{0, 0, 0, 1, 1000}},
+		{"LeakyReLU", &LeakyReLU{}, []float64{-10, -.01, 0, 1, 1000}},
+		{"ELU", &ELU{}, []float64{-1, math.Exp(-1) - 1, 0, 1, 1000}},
+		{"SELU", &SELU{}, []float64{-seluScale * seluAlpha, seluScale * seluAlpha * (math.Exp(-1) - 1), 0, seluScale, seluScale * 1000}},
+		{"GELU", &GELU{}, []float64{0, -0.15865525393145707, 0, 0.8413447460685429, 1000}},
+		{"Swish", &Swish{}, []float64{0, -1 / (1 + math.E), 0, 1 / (1 + math.Exp(-1)), 1000}},
+		{"Softplus", &Softplus{}, []float64{0, math.Log1p(math.Exp(-1)), math.Ln2, 1 + math.Log1p(math.Exp(-1)), 1000}},
+		{"Mish", &Mish{}, []float64{0, -math.Tanh(math.Log1p(math.Exp(-1))), 0, math.Tanh(1 + math.Log1p(math.Exp(-1))), 1000}},
+		{"HardTanh", &HardTanh{}, []float64{-1, -1, 0, 1, 1}},
+	}
+	for _, c := range cases {
+		out := c.layer.Forward(x)
+		for i, want := range c.want {
+			if got := out.X[i]; math.Abs(got-want) > 1e-9 || math.IsNaN(got) {
+				t.Errorf("%s(%v) = %v, want %v", c.name, x.X[i], got, want)
+			}
+		}
+		grad := c.layer.Backward(lab.Solid(5, 1, 1))
+		for i, g := range grad.X {
+			if math.IsNaN(g) || math.IsInf(g, 0) {
+				t.Errorf("%s'(%v) = %v", c.name, x.X[i], g)
+			}
+		}
+	}
+}
+
+func TestPReLUChannels(t *testing.T) {
+	p := NewPReLU(2)
+	p.Alpha.X[1] = .5
+	// Two channels of two rows each.
+	out := p.Forward(lab.Solid(4, 1, -2))
+	want := []float64{-.5, -.5, -1, -1}
+	for i := range want {
+		if out.X[i] != want[i] {
+			t.Fatalf("got %v, want %v", out.X, want)
+		}
+	}
+	p.Backward(lab.Solid(4, 1, 1))
+	if g := p.Params()[0].Grad; g.X[0] != -4 || g.X[1] != -4 {
+		t.Errorf("slope gradients %v, want [-4 -4]", g.X)
+	}
+}
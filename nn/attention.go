@@ -0,0 +1,498 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"math/rand"
+)
+
+// The layers in this file work on sequences stored like the recurrent
+// layers' input: steps of dim features stacked vertically, (steps*dim) x
+// batch.
+
+// stepsToCols rearranges a (steps*dim) x batch sequence into a
+// dim x (steps*batch) matrix whose column t*batch+j is step t of sample j.
+func stepsToCols(m *lab.Matrix, dim int) *lab.Matrix {
+	if dim == 0 || m.Rows%dim != 0 {
+		panic(fmt.Sprintf("nn: %d rows is not a sequence of %d-feature steps", m.Rows, dim))
+	}
+	steps, b := m.Rows/dim, m.Cols
+	out := lab.NewMatrix(dim, steps*b)
+	for t := 0; t < steps; t++ {
+		for d := 0; d < dim; d++ {
+			copy(out.X[d*steps*b+t*b:d*steps*b+(t+1)*b], m.X[(t*dim+d)*b:(t*dim+d+1)*b])
+		}
+	}
+	return out
+}
+
+// colsToSteps undoes stepsToCols for a batch of batch samples.
+func colsToSteps(m *lab.Matrix, batch int) *lab.Matrix {
+	steps, dim := m.Cols/batch, m.Rows
+	out := lab.NewMatrix(steps*dim, batch)
+	for t := 0; t < steps; t++ {
+		for d := 0; d < dim; d++ {
+			copy(out.X[(t*dim+d)*batch:(t*dim+d+1)*batch], m.X[d*m.Cols+t*batch:d*m.Cols+(t+1)*batch])
+		}
+	}
+	return out
+}
+
+// TimeDistributed applies Net to every step of a sequence of In-feature
+// steps, as if the steps were separate samples.
+type TimeDistributed struct {
+	In  int
+	Net *Network
+
+	steps, batch int
+}
+
+func NewTimeDistributed(in int, layers ...Layer) *TimeDistributed {
+	return &TimeDistributed{In: in, Net: &Network{Layers: layers}}
+}
+
+func (l *TimeDistributed) Params() []*Param {
+	return l.Net.Params()
+}
+
+func (l *TimeDistributed) Forward(matrix *lab.Matrix) *lab.Matrix {
+	l.steps, l.batch = matrix.Rows/l.In, matrix.Cols
+	return colsToSteps(l.Net.Forward(stepsToCols(matrix, l.In)), l.batch)
+}
+
+func (l *TimeDistributed) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return colsToSteps(l.Net.Backward(stepsToCols(matrix, matrix.Rows/l.steps)), l.batch)
+}
+
+func (l *TimeDistributed) Update(rate float64) {
+	l.Net.Update(rate)
+}
+
+func (l *TimeDistributed) Init(w, b Initializer, rng *rand.Rand) error {
+	return l.Net.Init(w, b, rng)
+}
+
+func (l *TimeDistributed) Seed(seed int64) {
+	l.Net.Seed(seed)
+}
+
+func (l *TimeDistributed) SetTraining(training bool) {
+	l.Net.SetTraining(training)
+}
+
+// LayerNorm normalizes every step of a sequence of Dim-feature steps to zero
+// mean and unit variance, then scales by Gain and shifts by Bias.
+type LayerNorm struct {
+	Dim  int
+	Eps  float64
+	Gain *lab.Matrix
+	Bias *lab.Matrix
+
+	dGain, dBias *lab.Matrix
+	// normalized input and inverse standard deviations of the last Forward,
+	// one column per step of each sample
+	xhat   *lab.Matrix
+	invStd []float64
+	batch  int
+}
+
+func NewLayerNorm(dim int) *LayerNorm {
+	return &LayerNorm{Dim: dim, Eps: 1e-5, Gain: lab.Solid(dim, 1, 1), Bias: lab.NewMatrix(dim, 1)}
+}
+
+func (l *LayerNorm) Params() []*Param {
+	if l.dGain == nil {
+		l.dGain = lab.NewMatrix(l.Dim, 1)
+		l.dBias = lab.NewMatrix(l.Dim, 1)
+	}
+	return []*Param{
+		{Name: "Gain", Value: l.Gain, Grad: l.dGain},
+		{Name: "Bias", Value: l.Bias, Grad: l.dBias},
+	}
+}
+
+func (l *LayerNorm) Forward(matrix *lab.Matrix) *lab.Matrix {
+	l.batch = matrix.Cols
+	x := stepsToCols(matrix, l.Dim)
+	n := x.Cols
+	l.xhat = lab.NewMatrix(l.Dim, n)
+	l.invStd = make([]float64, n)
+	out := lab.NewMatrix(l.Dim, n)
+	for j := 0; j < n; j++ {
+		var mean, variance float64
+		for d := 0; d < l.Dim; d++ {
+			mean += x.X[d*n+j]
+		}
+		mean /= float64(l.Dim)
+		for d := 0; d < l.Dim; d++ {
+			v := x.X[d*n+j] - mean
+			variance += v * v
+		}
+		inv := 1 / math.Sqrt(variance/float64(l.Dim)+l.Eps)
+		l.invStd[j] = inv
+		for d := 0; d < l.Dim; d++ {
+			xh := (x.X[d*n+j] - mean) * inv
+			l.xhat.X[d*n+j] = xh
+			out.X[d*n+j] = xh*l.Gain.X[d] + l.Bias.X[d]
+		}
+	}
+	return colsToSteps(out, l.batch)
+}
+
+func (l *LayerNorm) Backward(matrix *lab.Matrix) *lab.Matrix {
+	l.Params()
+	g := stepsToCols(matrix, l.Dim)
+	n := g.Cols
+	dx := lab.NewMatrix(l.Dim, n)
+	dim := float64(l.Dim)
+	for j := 0; j < n; j++ {
+		var sum, dot float64
+		for d := 0; d < l.Dim; d++ {
+			gd, xh := g.X[d*n+j], l.xhat.X[d*n+j]
+			l.dGain.X[d] += gd * xh
+			l.dBias.X[d] += gd
+			dxh := gd * l.Gain.X[d]
+			sum += dxh
+			dot += dxh * xh
+		}
+		for d := 0; d < l.Dim; d++ {
+			dxh := g.X[d*n+j] * l.Gain.X[d]
+			dx.X[d*n+j] = l.invStd[j] / dim * (dim*dxh - sum - l.xhat.X[d*n+j]*dot)
+		}
+	}
+	return colsToSteps(dx, l.batch)
+}
+
+func (l *LayerNorm) Update(rate float64) {
+	sgdUpdate(l, rate)
+}
+
+// PositionalEncoding adds the sinusoidal position encoding of Vaswani et al.
+// to every step of a sequence of Dim-feature steps.
+type PositionalEncoding struct {
+	Dim int
+}
+
+func positionCode(t, d, dim int) float64 {
+	angle := float64(t) / math.Pow(10000, float64(d-d%2)/float64(dim))
+	if d%2 == 0 {
+		return math.Sin(angle)
+	}
+	return math.Cos(angle)
+}
+
+func (p *PositionalEncoding) Forward(matrix *lab.Matrix) *lab.Matrix {
+	out := lab.NewMatrix(matrix.Rows, matrix.Cols).CopyFrom(matrix)
+	b := matrix.Cols
+	for r := 0; r < matrix.Rows; r++ {
+		code := positionCode(r/p.Dim, r%p.Dim, p.Dim)
+		for j := 0; j < b; j++ {
+			out.X[r*b+j] += code
+		}
+	}
+	return out
+}
+
+func (p *PositionalEncoding) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return matrix
+}
+
+func (p *PositionalEncoding) Update(float64) {
+}
+
+// softmaxRows replaces every row of s with its softmax, subtracting the row
+// maximum first for stability. If causal, entries right of the diagonal are
+// masked out and become 0.
+func softmaxRows(s *lab.Matrix, causal bool) {
+	for i := 0; i < s.Rows; i++ {
+		row := s.X[i*s.Cols : (i+1)*s.Cols]
+		n := len(row)
+		if causal && i+1 < n {
+			n = i + 1
+		}
+		max := row[0]
+		for _, v := range row[1:n] {
+			max = math.Max(max, v)
+		}
+		var denom float64
+		for k := range row[:n] {
+			row[k] = math.Exp(row[k] - max)
+			denom += row[k]
+		}
+		for k := range row {
+			if k < n {
+				row[k] /= denom
+			} else {
+				row[k] = 0
+			}
+		}
+	}
+}
+
+// MultiHeadAttention is scaled dot-product self-attention over a sequence of
+// Dim-feature steps, split into Heads heads of Dim/Heads features. With
+// Causal set, each step only attends to itself and earlier steps. Steps are
+// rows here: for a sample X (steps x Dim) the output is
+// concat_h(softmax(Q_h K_h^T / sqrt(Dim/Heads)) V_h) Wo with Q = X Wq,
+// K = X Wk and V = X Wv.
+type MultiHeadAttention struct {
+	Dim    int
+	Heads  int
+	Causal bool
+	Wq     *lab.Matrix
+	Wk     *lab.Matrix
+	Wv     *lab.Matrix
+	Wo     *lab.Matrix
+
+	grads []*lab.Matrix
+	// per-sample caches of the last Forward
+	cache []attentionCache
+}
+
+type attentionCache struct {
+	x, q, k, v, o *lab.Matrix
+	p             []*lab.Matrix
+}
+
+func NewMultiHeadAttention(dim, heads int, causal bool) *MultiHeadAttention {
+	if dim%heads != 0 {
+		panic(fmt.Sprintf("nn: %d features don't split into %d heads", dim, heads))
+	}
+	a := &MultiHeadAttention{
+		Dim:    dim,
+		Heads:  heads,
+		Causal: causal,
+		Wq:     lab.NewMatrix(dim, dim),
+		Wk:     lab.NewMatrix(dim, dim),
+		Wv:     lab.NewMatrix(dim, dim),
+		Wo:     lab.NewMatrix(dim, dim),
+	}
+	a.Init(LeCunNormal{}, nil, newRand())
+	return a
+}
+
+// Init initializes the four projections with w; there are no biases.
+func (a *MultiHeadAttention) Init(w, _ Initializer, rng *rand.Rand) error {
+	return initAll(w, nil, rng, []*lab.Matrix{a.Wq, a.Wk, a.Wv, a.Wo}, nil)
+}
+
+func (a *MultiHeadAttention) Params() []*Param {
+	values := []*lab.Matrix{a.Wq, a.Wk, a.Wv, a.Wo}
+	if a.grads == nil {
+		for _, v := range values {
+			a.grads = append(a.grads, lab.NewMatrix(v.Rows, v.Cols))
+		}
+	}
+	params := make([]*Param, len(values))
+	for i, name := range []string{"Wq", "Wk", "Wv", "Wo"} {
+		params[i] = &Param{Name: name, Value: values[i], Grad: a.grads[i]}
+	}
+	return params
+}
+
+// sample returns sample j of a (steps*dim) x batch sequence as a steps x dim
+// matrix.
+func sample(m *lab.Matrix, j, dim int) *lab.Matrix {
+	steps, b := m.Rows/dim, m.Cols
+	out := lab.NewMatrix(steps, dim)
+	for r := range out.X {
+		out.X[r] = m.X[r*b+j]
+	}
+	return out
+}
+
+// setSample writes the steps x dim matrix s as sample j of m.
+func setSample(m *lab.Matrix, j int, s *lab.Matrix) {
+	for r, v := range s.X {
+		m.X[r*m.Cols+j] = v
+	}
+}
+
+// headCols returns columns [h*dk, (h+1)*dk) of m.
+func headCols(m *lab.Matrix, h, dk int) *lab.Matrix {
+	return m.SubMatrix(0, h*dk, m.Rows, dk)
+}
+
+// addHeadCols adds s to columns [h*dk, (h+1)*dk) of m.
+func addHeadCols(m *lab.Matrix, h int, s *lab.Matrix) {
+	for i := 0; i < s.Rows; i++ {
+		for k := 0; k < s.Cols; k++ {
+			m.X[i*m.Cols+h*s.Cols+k] += s.X[i*s.Cols+k]
+		}
+	}
+}
+
+func (a *MultiHeadAttention) Forward(matrix *lab.Matrix) *lab.Matrix {
+	if matrix.Rows%a.Dim != 0 {
+		panic(fmt.Sprintf("nn: %d rows is not a sequence of %d-feature steps", matrix.Rows, a.Dim))
+	}
+	dk := a.Dim / a.Heads
+	scale := 1 / math.Sqrt(float64(dk))
+	out := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	a.cache = a.cache[:0]
+	for j := 0; j < matrix.Cols; j++ {
+		c := attentionCache{x: sample(matrix, j, a.Dim)}
+		c.q, c.k, c.v = c.x.Multiply(a.Wq), c.x.Multiply(a.Wk), c.x.Multiply(a.Wv)
+		c.o = lab.NewMatrix(c.x.Rows, a.Dim)
+		for h := 0; h < a.Heads; h++ {
+			s := headCols(c.q, h, dk).Multiply(headCols(c.k, h, dk).Transpose()).Scale(scale)
+			softmaxRows(s, a.Causal)
+			c.p = append(c.p, s)
+			addHeadCols(c.o, h, s.Multiply(headCols(c.v, h, dk)))
+		}
+		setSample(out, j, c.o.Multiply(a.Wo))
+		a.cache = append(a.cache, c)
+	}
+	return out
+}
+
+func (a *MultiHeadAttention) Backward(matrix *lab.Matrix) *lab.Matrix {
+	params := a.Params()
+	dWq, dWk, dWv, dWo := params[0].Grad, params[1].Grad, params[2].Grad, params[3].Grad
+	dk := a.Dim / a.Heads
+	scale := 1 / math.Sqrt(float64(dk))
+	ret := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	for j, c := range a.cache {
+		dy := sample(matrix, j, a.Dim)
+		dWo.AddScaled(1, c.o.Transpose().Multiply(dy))
+		do := dy.Multiply(a.Wo.Transpose())
+		dq := lab.NewMatrix(c.q.Rows, c.q.Cols)
+		dkm := lab.NewMatrix(c.k.Rows, c.k.Cols)
+		dv := lab.NewMatrix(c.v.Rows, c.v.Cols)
+		for h := 0; h < a.Heads; h++ {
+			p, doh := c.p[h], headCols(do, h, dk)
+			addHeadCols(dv, h, p.Transpose().Multiply(doh))
+			dp := doh.Multiply(headCols(c.v, h, dk).Transpose())
+			// Softmax backward, row by row: ds = p * (dp - sum(dp * p)).
+			ds := lab.NewMatrix(p.Rows, p.Cols)
+			for i := 0; i < p.Rows; i++ {
+				var dot float64
+				for k := 0; k < p.Cols; k++ {
+					dot += dp.X[i*p.Cols+k] * p.X[i*p.Cols+k]
+				}
+				for k := 0; k < p.Cols; k++ {
+					ds.X[i*p.Cols+k] = p.X[i*p.Cols+k] * (dp.X[i*p.Cols+k] - dot) * scale
+				}
+			}
+			addHeadCols(dq, h, ds.Multiply(headCols(c.k, h, dk)))
+			addHeadCols(dkm, h, ds.Transpose().Multiply(headCols(c.q, h, dk)))
+		}
+		xT := c.x.Transpose()
+		dWq.AddScaled(1, xT.Multiply(dq))
+		dWk.AddScaled(1, xT.Multiply(dkm))
+		dWv.AddScaled(1, xT.Multiply(dv))
+		dx := dq.Multiply(a.Wq.Transpose())
+		lab.AddInto(dx, dx, dkm.Multiply(a.Wk.Transpose()))
+		lab.AddInto(dx, dx, dv.Multiply(a.Wv.Transpose()))
+		setSample(ret, j, dx)
+	}
+	return ret
+}
+
+func (a *MultiHeadAttention) Update(rate float64) {
+	sgdUpdate(a, rate)
+}
+
+// TransformerBlock is a pre-norm Transformer encoder block:
+//
+//	a = x + Attn(LN1(x))
+//	y = a + FF(LN2(a))
+//
+// where FF is a two-layer RELU network applied to every step.
+type TransformerBlock struct {
+	LN1  *LayerNorm
+	Attn *MultiHeadAttention
+	LN2  *LayerNorm
+	FF   *TimeDistributed
+}
+
+// NewTransformerBlock returns a block over dim-feature steps with the given
+// number of attention heads and hidden units in the feed-forward network.
+func NewTransformerBlock(dim, heads, hidden int, causal bool) *TransformerBlock {
+	return &TransformerBlock{
+		LN1:  NewLayerNorm(dim),
+		Attn: NewMultiHeadAttention(dim, heads, causal),
+		LN2:  NewLayerNorm(dim),
+		FF:   NewTimeDistributed(dim, NewFCLayer(dim, hidden), &RELU{}, NewFCLayer(hidden, dim)),
+	}
+}
+
+func (b *TransformerBlock) layers() map[string]Parameterized {
+	return map[string]Parameterized{"ln1": b.LN1, "attn": b.Attn, "ln2": b.LN2, "ff": b.FF}
+}
+
+// Params names the parameters of the parts "ln1.", "attn.", "ln2." and "ff.".
+func (b *TransformerBlock) Params() []*Param {
+	var params []*Param
+	for _, name := range []string{"ln1", "attn", "ln2", "ff"} {
+		for _, p := range b.layers()[name].Params() {
+			params = append(params, &Param{Name: name + "." + p.Name, Value: p.Value, Grad: p.Grad})
+		}
+	}
+	return params
+}
+
+func (b *TransformerBlock) Forward(matrix *lab.Matrix) *lab.Matrix {
+	a := matrix.Add(b.Attn.Forward(b.LN1.Forward(matrix)))
+	return a.Add(b.FF.Forward(b.LN2.Forward(a)))
+}
+
+func (b *TransformerBlock) Backward(matrix *lab.Matrix) *lab.Matrix {
+	da := matrix.Add(b.LN2.Backward(b.FF.Backward(matrix)))
+	return da.Add(b.LN1.Backward(b.Attn.Backward(da)))
+}
+
+func (b *TransformerBlock) Update(rate float64) {
+	sgdUpdate(b, rate)
+}
+
+// Init initializes the attention projections and the feed-forward network;
+// the layer norms keep their unit gains.
+func (b *TransformerBlock) Init(w, bias Initializer, rng *rand.Rand) error {
+	if err := b.Attn.Init(w, bias, rng); err != nil {
+		return err
+	}
+	return b.FF.Init(w, bias, rng)
+}
+
+func (b *TransformerBlock) Seed(seed int64) {
+	b.FF.Seed(seed)
+}
+
+func (b *TransformerBlock) SetTraining(training bool) {
+	b.FF.SetTraining(training)
+}
+
+// SequenceLoss applies Inner to every step of a sequence of Dim-feature
+// outputs, treating the steps as separate samples. Targets for the steps are
+// stacked the same way, so for SoftMaxCrossEntropy they are a steps x batch
+// matrix of classes.
+type SequenceLoss struct {
+	Inner Loss
+	Dim   int
+
+	target *lab.Matrix
+	batch  int
+}
+
+func (s *SequenceLoss) SetTarget(y *lab.Matrix) {
+	s.target = y
+}
+
+func (s *SequenceLoss) Loss(matrix *lab.Matrix) float64 {
+	s.batch = matrix.Cols
+	if tg, ok := s.Inner.(Targeted); ok && s.target != nil {
+		steps := matrix.Rows / s.Dim
+		tg.SetTarget(stepsToCols(s.target, s.target.Rows/steps))
+	}
+	return s.Inner.Loss(stepsToCols(matrix, s.Dim))
+}
+
+func (s *SequenceLoss) Backward() *lab.Matrix {
+	return colsToSteps(s.Inner.Backward(), s.batch)
+}
+
+func (s *SequenceLoss) Reset() {
+	s.Inner.Reset()
+}
@@ -0,0 +1,66 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"path/filepath"
+	"testing"
+)
+
+func TestCausalAttention(t *testing.T) {
+	// Changing a later step must not change earlier outputs.
+	block := NewTransformerBlock(4, 2, 8, true)
+	x := lab.Gaussian(4*5, 2)
+	before := block.Forward(x)
+	x.X[len(x.X)-1] += 10
+	after := block.Forward(x)
+	for i := 0; i < 4*4*2; i++ {
+		if before.X[i] != after.X[i] {
+			t.Fatalf("output %d of an earlier step changed", i)
+		}
+	}
+	if before.X[len(x.X)-1] == after.X[len(x.X)-1] {
+		t.Error("last step ignores its own input")
+	}
+}
+
+func TestLayerNormNormalizes(t *testing.T) {
+	ln := NewLayerNorm(6)
+	out := stepsToCols(ln.Forward(lab.Gaussian(6*3, 2).Scale(5)), 6)
+	for j := 0; j < out.Cols; j++ {
+		var mean, sq float64
+		for d := 0; d < 6; d++ {
+			v := out.Access(d, j)
+			mean += v / 6
+			sq += v * v / 6
+		}
+		if mean > 1e-9 || mean < -1e-9 || sq-mean*mean < .99 || sq-mean*mean > 1.01 {
+			t.Errorf("step %d: mean %v, variance %v", j, mean, sq-mean*mean)
+		}
+	}
+}
+
+func TestSaveLoadTransformer(t *testing.T) {
+	model := &Network{Layers: []Layer{
+		NewTimeDistributed(3, NewFCLayer(3, 4)),
+		&PositionalEncoding{Dim: 4},
+		NewTransformerBlock(4, 2, 8, true),
+	}}
+	fname := filepath.Join(t.TempDir(), "transformer.json")
+	if err := model.SaveModel(fname); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadModel(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := lab.Gaussian(3*5, 2)
+	want, got := model.Forward(x), loaded.Forward(x)
+	for i := range want.X {
+		if want.X[i] != got.X[i] {
+			t.Fatalf("output %d: got %v, want %v", i, got.X[i], want.X[i])
+		}
+	}
+	if len(loaded.Params()) != len(model.Params()) {
+		t.Errorf("loaded %d params, want %d", len(loaded.Params()), len(model.Params()))
+	}
+}
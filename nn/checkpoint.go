@@ -0,0 +1,331 @@
+package nn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Checkpoint file layout, all integers little-endian:
+//
+//	magic    [4]byte "NNCK"
+//	version  uint32
+//	epoch    int64
+//	step     int64
+//	seed     int64
+//	archLen  uint32, then archLen bytes of model JSON without weights
+//	count    uint32, then count tensors:
+//	         nameLen uint16, name, dtype uint8 (4 or 8), rows uint32,
+//	         cols uint32, rows*cols floats
+//	crc      uint32, IEEE CRC-32 of everything before it
+//
+// Parameters are stored as "param/<name>", using the names from
+// Network.Params, optimizer state as "state/<name>" and learning rate
+// schedule state as 1x1 tensors named "sched/<name>".
+const (
+	checkpointMagic   = "NNCK"
+	checkpointVersion = 1
+
+	dtypeFloat32 = 4
+	dtypeFloat64 = 8
+)
+
+var ErrBadChecksum = errors.New("nn: checkpoint checksum mismatch")
+
+// Checkpoint is everything needed to resume training: the model with its
+// weights, the optimizer state, how far training got and the seed it used.
+type Checkpoint struct {
+	Model *Network
+	// OptimizerState is the result of Optimizer.State when saving, and should
+	// be passed to Optimizer.SetState after loading.
+	OptimizerState map[string]*lab.Matrix
+	// ScheduleState is the result of Schedule.State.
+	ScheduleState map[string]float64
+	Epoch         int
+	Step          int
+	Seed          int64
+
+	// Float32 stores the weights as float32 to halve the file size. Optimizer
+	// state is always stored as float64.
+	Float32 bool
+}
+
+type namedTensor struct {
+	name  string
+	dtype uint8
+	m     *lab.Matrix
+}
+
+// Save writes the checkpoint to fileName.
+func (c *Checkpoint) Save(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := c.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Write encodes the checkpoint to w.
+func (c *Checkpoint) Write(w io.Writer) error {
+	params := c.Model.Params()
+	arch, err := archJSON(c.Model)
+	if err != nil {
+		return err
+	}
+
+	weightType := uint8(dtypeFloat64)
+	if c.Float32 {
+		weightType = dtypeFloat32
+	}
+	var tensors []namedTensor
+	for _, p := range params {
+		tensors = append(tensors, namedTensor{"param/" + p.Name, weightType, p.Value})
+	}
+	names := make([]string, 0, len(c.OptimizerState))
+	for name := range c.OptimizerState {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tensors = append(tensors, namedTensor{"state/" + name, dtypeFloat64, c.OptimizerState[name]})
+	}
+	names = names[:0]
+	for name := range c.ScheduleState {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tensors = append(tensors, namedTensor{"sched/" + name, dtypeFloat64, lab.Solid(1, 1, c.ScheduleState[name])})
+	}
+
+	buf := bufio.NewWriter(w)
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(buf, crc)
+	le := binary.LittleEndian
+
+	header := []interface{}{
+		[]byte(checkpointMagic),
+		uint32(checkpointVersion),
+		int64(c.Epoch),
+		int64(c.Step),
+		c.Seed,
+		uint32(len(arch)),
+		arch,
+		uint32(len(tensors)),
+	}
+	for _, v := range header {
+		if err := binary.Write(out, le, v); err != nil {
+			return err
+		}
+	}
+	for _, t := range tensors {
+		if err := writeTensor(out, t); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(buf, le, crc.Sum32()); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// archJSON encodes the model with the data of its parameters left out, since
+// the weights are stored separately as binary tensors. The data is cleared on
+// a decoded copy so that the model itself is never touched.
+func archJSON(model *Network) ([]byte, error) {
+	full, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	var arch Network
+	if err := json.Unmarshal(full, &arch); err != nil {
+		return nil, err
+	}
+	for _, p := range arch.Params() {
+		p.Value.X = nil
+	}
+	return json.Marshal(&arch)
+}
+
+func writeTensor(w io.Writer, t namedTensor) error {
+	le := binary.LittleEndian
+	if len(t.name) > math.MaxUint16 {
+		return fmt.Errorf("nn: tensor name too long: %q", t.name)
+	}
+	for _, v := range []interface{}{
+		uint16(len(t.name)),
+		[]byte(t.name),
+		t.dtype,
+		uint32(t.m.Rows),
+		uint32(t.m.Cols),
+	} {
+		if err := binary.Write(w, le, v); err != nil {
+			return err
+		}
+	}
+	if t.dtype == dtypeFloat32 {
+		b := make([]byte, 4*len(t.m.X))
+		for i, x := range t.m.X {
+			le.PutUint32(b[4*i:], math.Float32bits(float32(x)))
+		}
+		_, err := w.Write(b)
+		return err
+	}
+	b := make([]byte, 8*len(t.m.X))
+	for i, x := range t.m.X {
+		le.PutUint64(b[8*i:], math.Float64bits(x))
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// LoadCheckpoint reads a checkpoint written by Save.
+func LoadCheckpoint(fileName string) (*Checkpoint, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCheckpoint(f)
+}
+
+// ReadCheckpoint decodes a checkpoint from r, verifying its checksum and
+// rebuilding the model from the registered layer types.
+func ReadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(checkpointMagic)+4+4 || string(data[:len(checkpointMagic)]) != checkpointMagic {
+		return nil, errors.New("nn: not a checkpoint file")
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, ErrBadChecksum
+	}
+
+	br := bytes.NewReader(body[len(checkpointMagic):])
+	le := binary.LittleEndian
+	var version uint32
+	if err := binary.Read(br, le, &version); err != nil {
+		return nil, err
+	}
+	if version != checkpointVersion {
+		return nil, fmt.Errorf("nn: unsupported checkpoint version %d", version)
+	}
+	var epoch, step, seed int64
+	var archLen uint32
+	for _, v := range []interface{}{&epoch, &step, &seed, &archLen} {
+		if err := binary.Read(br, le, v); err != nil {
+			return nil, err
+		}
+	}
+	if int64(archLen) > int64(br.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	arch := make([]byte, archLen)
+	if _, err := io.ReadFull(br, arch); err != nil {
+		return nil, err
+	}
+	model := &Network{}
+	if err := json.Unmarshal(arch, model); err != nil {
+		return nil, err
+	}
+
+	var count uint32
+	if err := binary.Read(br, le, &count); err != nil {
+		return nil, err
+	}
+	tensors := map[string]*lab.Matrix{}
+	for i := uint32(0); i < count; i++ {
+		name, m, err := readTensor(br)
+		if err != nil {
+			return nil, fmt.Errorf("nn: reading tensor %d: %v", i, err)
+		}
+		tensors[name] = m
+	}
+
+	for _, p := range model.Params() {
+		m, ok := tensors["param/"+p.Name]
+		if !ok {
+			return nil, fmt.Errorf("nn: checkpoint has no weights for %s", p.Name)
+		}
+		if m.Rows != p.Value.Rows || m.Cols != p.Value.Cols {
+			return nil, fmt.Errorf("nn: weights for %s are %dx%d, model expects %dx%d",
+				p.Name, m.Rows, m.Cols, p.Value.Rows, p.Value.Cols)
+		}
+		p.Value.X = m.X
+	}
+	state := map[string]*lab.Matrix{}
+	sched := map[string]float64{}
+	for name, m := range tensors {
+		if strings.HasPrefix(name, "state/") {
+			state[strings.TrimPrefix(name, "state/")] = m
+		}
+		if strings.HasPrefix(name, "sched/") && len(m.X) == 1 {
+			sched[strings.TrimPrefix(name, "sched/")] = m.X[0]
+		}
+	}
+
+	return &Checkpoint{
+		Model:          model,
+		OptimizerState: state,
+		ScheduleState:  sched,
+		Epoch:          int(epoch),
+		Step:           int(step),
+		Seed:           seed,
+	}, nil
+}
+
+func readTensor(r *bytes.Reader) (string, *lab.Matrix, error) {
+	le := binary.LittleEndian
+	var nameLen uint16
+	if err := binary.Read(r, le, &nameLen); err != nil {
+		return "", nil, err
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", nil, err
+	}
+	var dtype uint8
+	var rows, cols uint32
+	for _, v := range []interface{}{&dtype, &rows, &cols} {
+		if err := binary.Read(r, le, v); err != nil {
+			return "", nil, err
+		}
+	}
+	if dtype != dtypeFloat32 && dtype != dtypeFloat64 {
+		return "", nil, fmt.Errorf("unknown dtype %d", dtype)
+	}
+	n := int64(rows) * int64(cols)
+	if n*int64(dtype) > int64(r.Len()) {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n*int64(dtype))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", nil, err
+	}
+	m := lab.NewMatrix(int(rows), int(cols))
+	for i := range m.X {
+		if dtype == dtypeFloat32 {
+			m.X[i] = float64(math.Float32frombits(le.Uint32(b[4*i:])))
+		} else {
+			m.X[i] = math.Float64frombits(le.Uint64(b[8*i:]))
+		}
+	}
+	return string(name), m, nil
+}
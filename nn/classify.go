@@ -0,0 +1,264 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+)
+
+// logSoftmaxCols returns the log-softmax of every column of m, subtracting
+// the column maximum first for stability.
+func logSoftmaxCols(m *lab.Matrix) *lab.Matrix {
+	ret := lab.NewMatrix(m.Rows, m.Cols)
+	for j := 0; j < m.Cols; j++ {
+		max := m.Access(0, j)
+		for i := 1; i < m.Rows; i++ {
+			max = math.Max(max, m.Access(i, j))
+		}
+		var denom float64
+		for i := 0; i < m.Rows; i++ {
+			denom += math.Exp(m.Access(i, j) - max)
+		}
+		logZ := max + math.Log(denom)
+		for i := 0; i < m.Rows; i++ {
+			ret.Set(i, j, m.Access(i, j)-logZ)
+		}
+	}
+	return ret
+}
+
+// classes rounds a 1 x batch matrix of class indices to ints.
+func classes(y *lab.Matrix, into []int) []int {
+	into = into[:0]
+	for _, c := range y.X {
+		into = append(into, int(math.Round(c)))
+	}
+	return into
+}
+
+// Softmax turns every column of scores into a probability distribution, e.g.
+// to read class probabilities off a classifier at inference. To train, feed
+// the scores to SoftMaxCrossEntropy instead, which is more stable.
+type Softmax struct {
+	out *lab.Matrix
+}
+
+func (s *Softmax) Forward(matrix *lab.Matrix) *lab.Matrix {
+	s.out = logSoftmaxCols(matrix).Exp()
+	return s.out
+}
+
+func (s *Softmax) Backward(matrix *lab.Matrix) *lab.Matrix {
+	ret := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	for j := 0; j < matrix.Cols; j++ {
+		var dot float64
+		for i := 0; i < matrix.Rows; i++ {
+			dot += matrix.Access(i, j) * s.out.Access(i, j)
+		}
+		for i := 0; i < matrix.Rows; i++ {
+			ret.Set(i, j, s.out.Access(i, j)*(matrix.Access(i, j)-dot))
+		}
+	}
+	return ret
+}
+
+func (s *Softmax) Update(float64) {
+}
+
+// LogSoftmax turns every column of scores into log-probabilities, the input
+// NLLLoss expects.
+type LogSoftmax struct {
+	out *lab.Matrix
+}
+
+func (s *LogSoftmax) Forward(matrix *lab.Matrix) *lab.Matrix {
+	s.out = logSoftmaxCols(matrix)
+	return s.out
+}
+
+func (s *LogSoftmax) Backward(matrix *lab.Matrix) *lab.Matrix {
+	ret := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	for j := 0; j < matrix.Cols; j++ {
+		var sum float64
+		for i := 0; i < matrix.Rows; i++ {
+			sum += matrix.Access(i, j)
+		}
+		for i := 0; i < matrix.Rows; i++ {
+			ret.Set(i, j, matrix.Access(i, j)-math.Exp(s.out.Access(i, j))*sum)
+		}
+	}
+	return ret
+}
+
+func (s *LogSoftmax) Update(float64) {
+}
+
+// SoftMaxCrossEntropy is the cross-entropy between the softmax of size
+// scores per column and a target distribution: a single class, or with soft
+// targets any distribution. Smoothing mixes the target with the uniform
+// distribution, (1-Smoothing)*target + Smoothing/size. Weights, if set, weighs
+// the term of every class. The loss is reduced over the columns.
+type SoftMaxCrossEntropy struct {
+	crossEntropy float64
+	gradients    *lab.Matrix
+	size         int
+	// Target is the class of a single column input. Batches use Targets,
+	// which holds one class per column, or Soft.
+	Target  int
+	Targets []int
+	// Soft holds a size x batch matrix of target distributions, used
+	// instead of Targets if set.
+	Soft *lab.Matrix
+
+	Smoothing float64
+	Weights   []float64
+	Reduction Reduction
+}
+
+func NewSoftMaxCrossEntropy(size int) *SoftMaxCrossEntropy {
+	return &SoftMaxCrossEntropy{
+		size:      size,
+		gradients: lab.NewMatrix(size, 1),
+	}
+}
+
+func (s *SoftMaxCrossEntropy) Backward() *lab.Matrix {
+	return s.gradients
+}
+
+func (s *SoftMaxCrossEntropy) Reset() {
+	s.crossEntropy = 0
+	s.gradients = lab.NewMatrix(s.size, 1)
+}
+
+// SetTarget takes the classes of a batch as a 1 x batch matrix, or target
+// distributions as a size x batch matrix.
+func (s *SoftMaxCrossEntropy) SetTarget(y *lab.Matrix) {
+	if y.Rows == s.size && s.size > 1 {
+		s.Soft, s.Targets = y, s.Targets[:0]
+		return
+	}
+	s.Soft = nil
+	s.Targets = classes(y, s.Targets)
+}
+
+func (s *SoftMaxCrossEntropy) weight(i int) float64 {
+	if s.Weights == nil {
+		return 1
+	}
+	return s.Weights[i]
+}
+
+// target fills q with the smoothed target distribution of column j.
+func (s *SoftMaxCrossEntropy) target(j int, targets []int, q []float64) {
+	for i := range q {
+		q[i] = 0
+	}
+	if s.Soft != nil {
+		for i := range q {
+			q[i] = s.Soft.Access(i, j)
+		}
+	} else {
+		q[targets[j]] = 1
+	}
+	if s.Smoothing != 0 {
+		for i := range q {
+			q[i] = (1-s.Smoothing)*q[i] + s.Smoothing/float64(len(q))
+		}
+	}
+}
+
+func (s *SoftMaxCrossEntropy) Loss(mat *lab.Matrix) float64 {
+	targets := s.Targets
+	if s.Soft != nil {
+		if s.Soft.Cols != mat.Cols {
+			panic("number of targets doesn't match batch size")
+		}
+	} else {
+		if len(targets) == 0 {
+			targets = []int{s.Target}
+		}
+		if len(targets) != mat.Cols {
+			panic("number of targets doesn't match batch size")
+		}
+	}
+	s.gradients = lab.Ensure(s.gradients, s.size, mat.Cols)
+	scale := s.Reduction.scale(mat.Cols)
+	logp := logSoftmaxCols(mat)
+	q := make([]float64, s.size)
+	for j := 0; j < mat.Cols; j++ {
+		s.target(j, targets, q)
+		// With weights w the gradient of -sum w_i q_i log p_i with respect
+		// to score k is p_k sum w_i q_i - w_k q_k, which is p - q
+		// unweighted.
+		var wq float64
+		for i, qi := range q {
+			if qi != 0 {
+				wq += s.weight(i) * qi
+				s.crossEntropy -= scale * s.weight(i) * qi * logp.Access(i, j)
+			}
+		}
+		for i, qi := range q {
+			s.gradients.Set(i, j, scale*(math.Exp(logp.Access(i, j))*wq-s.weight(i)*qi))
+		}
+	}
+	return s.crossEntropy
+}
+
+// NLLLoss is the negative log-likelihood of the target classes given
+// log-probabilities, such as LogSoftmax output, one class per row. Weights,
+// if set, weighs the term of every class. The loss is reduced over the
+// columns.
+type NLLLoss struct {
+	Classes   int
+	Weights   []float64
+	Targets   []int
+	Reduction Reduction
+
+	nll       float64
+	gradients *lab.Matrix
+}
+
+func NewNLLLoss(classes int) *NLLLoss {
+	return &NLLLoss{Classes: classes, gradients: lab.NewMatrix(classes, 1)}
+}
+
+// SetTarget takes the classes of a batch as a 1 x batch matrix.
+func (n *NLLLoss) SetTarget(y *lab.Matrix) {
+	n.Targets = classes(y, n.Targets)
+}
+
+func (n *NLLLoss) Loss(mat *lab.Matrix) float64 {
+	if len(n.Targets) != mat.Cols {
+		panic(fmt.Sprintf("nn: %d targets for a batch of %d", len(n.Targets), mat.Cols))
+	}
+	if mat.Rows != n.Classes {
+		panic(fmt.Sprintf("nn: NLLLoss of %d classes given %d rows", n.Classes, mat.Rows))
+	}
+	n.gradients = lab.Ensure(n.gradients, n.Classes, mat.Cols)
+	for i := range n.gradients.X {
+		n.gradients.X[i] = 0
+	}
+	scale := n.Reduction.scale(mat.Cols)
+	for j, c := range n.Targets {
+		if c < 0 || c >= n.Classes {
+			panic(fmt.Sprintf("nn: target %d of column %d is not one of %d classes", c, j, n.Classes))
+		}
+		w := scale
+		if n.Weights != nil {
+			w *= n.Weights[c]
+		}
+		n.nll -= w * mat.Access(c, j)
+		n.gradients.Set(c, j, -w)
+	}
+	return n.nll
+}
+
+func (n *NLLLoss) Backward() *lab.Matrix {
+	return n.gradients
+}
+
+func (n *NLLLoss) Reset() {
+	n.nll = 0
+	n.gradients = lab.NewMatrix(n.Classes, 1)
+}
@@ -0,0 +1,68 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"testing"
+)
+
+func TestCrossEntropyValues(t *testing.T) {
+	// Softmax probabilities .1, .2, .3, .4.
+	logits := lab.NewVector([]float64{0, math.Log(2), math.Log(3), math.Log(4)}).Col()
+	p := []float64{.1, .2, .3, .4}
+	probs := (&Softmax{}).Forward(logits)
+	for i := range p {
+		if math.Abs(probs.X[i]-p[i]) > 1e-12 {
+			t.Fatalf("softmax %v, want %v", probs.X, p)
+		}
+	}
+
+	smoothed := -(.05*math.Log(.1) + .05*math.Log(.2) + .05*math.Log(.3) + .85*math.Log(.4))
+	cases := []struct {
+		name string
+		ce   *SoftMaxCrossEntropy
+		want float64
+	}{
+		{"hard", &SoftMaxCrossEntropy{size: 4, Target: 3}, -math.Log(.4)},
+		{"soft", &SoftMaxCrossEntropy{size: 4, Soft: lab.NewVector([]float64{0, .5, 0, .5}).Col()}, -(.5*math.Log(.2) + .5*math.Log(.4))},
+		{"smoothed", &SoftMaxCrossEntropy{size: 4, Target: 3, Smoothing: .2}, smoothed},
+		{"weighted", &SoftMaxCrossEntropy{size: 4, Target: 3, Weights: []float64{1, 1, 1, 2}}, -2 * math.Log(.4)},
+	}
+	for _, c := range cases {
+		if got := c.ce.Loss(logits); math.Abs(got-c.want) > 1e-12 {
+			t.Errorf("%s: loss %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	nll := NewNLLLoss(4)
+	nll.SetTarget(lab.NewVector([]float64{3}).Row())
+	if got := nll.Loss((&LogSoftmax{}).Forward(logits)); math.Abs(got+math.Log(.4)) > 1e-12 {
+		t.Errorf("NLLLoss of LogSoftmax %v, want %v", got, -math.Log(.4))
+	}
+}
+
+func TestNLLLossChecksTargets(t *testing.T) {
+	logp := (&LogSoftmax{}).Forward(lab.NewMatrix(4, 2))
+	cases := []struct {
+		name    string
+		classes int
+		targets []int
+	}{
+		{"rows", 3, []int{0, 1}},
+		{"negative", 4, []int{0, -1}},
+		{"too large", 4, []int{4, 1}},
+		{"count", 4, []int{0}},
+	}
+	for _, c := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: NLLLoss didn't panic", c.name)
+				}
+			}()
+			nll := NewNLLLoss(c.classes)
+			nll.Targets = c.targets
+			nll.Loss(logp)
+		}()
+	}
+}
@@ -0,0 +1,152 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+)
+
+// Clipper limits gradients before an optimizer step and counts how often it
+// had to.
+type Clipper interface {
+	// Clip rescales or clamps the gradients of params in place and reports
+	// whether any gradient was changed.
+	Clip(params []*Param) bool
+	Stats() ClipStats
+}
+
+// ClipStats records how many times a clipper ran and how many of those times
+// it changed the gradients.
+type ClipStats struct {
+	Steps   int
+	Clipped int
+}
+
+// Rate is the fraction of steps on which clipping fired.
+func (s ClipStats) Rate() float64 {
+	if s.Steps == 0 {
+		return 0
+	}
+	return float64(s.Clipped) / float64(s.Steps)
+}
+
+func (s *ClipStats) record(clipped bool) bool {
+	s.Steps++
+	if clipped {
+		s.Clipped++
+	}
+	return clipped
+}
+
+// ClipValue clamps every gradient element to [-Max, Max].
+type ClipValue struct {
+	Max   float64
+	stats ClipStats
+}
+
+func (c *ClipValue) Clip(params []*Param) bool {
+	var clipped bool
+	for _, p := range params {
+		for i, g := range p.Grad.X {
+			if g > c.Max {
+				p.Grad.X[i] = c.Max
+				clipped = true
+			} else if g < -c.Max {
+				p.Grad.X[i] = -c.Max
+				clipped = true
+			}
+		}
+	}
+	return c.stats.record(clipped)
+}
+
+func (c *ClipValue) Stats() ClipStats {
+	return c.stats
+}
+
+// ClipNorm rescales the gradient of each parameter separately so that its L2
+// norm is at most Max.
+type ClipNorm struct {
+	Max   float64
+	stats ClipStats
+}
+
+func (c *ClipNorm) Clip(params []*Param) bool {
+	var clipped bool
+	for _, p := range params {
+		if norm := l2(p.Grad); norm > c.Max {
+			p.Grad.ScaleInPlace(c.Max / norm)
+			clipped = true
+		}
+	}
+	return c.stats.record(clipped)
+}
+
+func (c *ClipNorm) Stats() ClipStats {
+	return c.stats
+}
+
+// ClipGlobalNorm rescales all gradients by the same factor so that their
+// combined L2 norm is at most Max, preserving the direction of the update.
+type ClipGlobalNorm struct {
+	Max   float64
+	stats ClipStats
+}
+
+func (c *ClipGlobalNorm) Clip(params []*Param) bool {
+	var sq float64
+	for _, p := range params {
+		n := l2(p.Grad)
+		sq += n * n
+	}
+	norm := math.Sqrt(sq)
+	if norm <= c.Max {
+		return c.stats.record(false)
+	}
+	for _, p := range params {
+		p.Grad.ScaleInPlace(c.Max / norm)
+	}
+	return c.stats.record(true)
+}
+
+func (c *ClipGlobalNorm) Stats() ClipStats {
+	return c.stats
+}
+
+// NewClipper returns a clipper by name: value, norm or global.
+func NewClipper(name string, max float64) (Clipper, error) {
+	switch name {
+	case "value":
+		return &ClipValue{Max: max}, nil
+	case "norm":
+		return &ClipNorm{Max: max}, nil
+	case "global":
+		return &ClipGlobalNorm{Max: max}, nil
+	}
+	return nil, fmt.Errorf("nn: unknown clipping policy %q", name)
+}
+
+func l2(m *lab.Matrix) float64 {
+	var sq float64
+	for _, x := range m.X {
+		sq += x * x
+	}
+	return math.Sqrt(sq)
+}
+
+// ClippedOptimizer clips gradients with Clipper before every step of
+// Optimizer.
+type ClippedOptimizer struct {
+	Optimizer
+	Clipper Clipper
+}
+
+// WithClipping wraps opt so that c is applied to the gradients first.
+func WithClipping(opt Optimizer, c Clipper) *ClippedOptimizer {
+	return &ClippedOptimizer{Optimizer: opt, Clipper: c}
+}
+
+func (o *ClippedOptimizer) Step(params []*Param, rate float64) {
+	o.Clipper.Clip(params)
+	o.Optimizer.Step(params, rate)
+}
@@ -0,0 +1,422 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math/rand"
+)
+
+// Shape is the channels x height x width layout of an image sample. Spatial
+// layers store each sample as a column in channel, row, column order, so
+// element (c, h, w) is at index (c*H+h)*W+w.
+type Shape struct {
+	C, H, W int
+}
+
+func (s Shape) Size() int {
+	return s.C * s.H * s.W
+}
+
+func (s Shape) String() string {
+	return fmt.Sprintf("%dx%dx%d", s.C, s.H, s.W)
+}
+
+func checkShape(layer string, s Shape, m *lab.Matrix) {
+	if m.Rows != s.Size() {
+		panic(fmt.Sprintf("nn: %s expects %v (%d) features, got %d", layer, s, s.Size(), m.Rows))
+	}
+}
+
+// Conv2D is a 2D convolution of Filters square kernels of side Kernel over
+// inputs of shape In. Stride and Dilation of 0 mean 1; Padding zeros are
+// added on every side.
+type Conv2D struct {
+	In       Shape
+	Filters  int
+	Kernel   int
+	Stride   int
+	Padding  int
+	Dilation int
+
+	// W holds one filter per row, laid out like the input: channel, row,
+	// column.
+	W *lab.Matrix
+	B *lab.Matrix
+
+	Wprime *lab.Matrix `json:"-"`
+	Bprime *lab.Matrix `json:"-"`
+
+	// scratch space: the unrolled input patches of the last Forward, the
+	// outputs as filters x positions, and buffers reused by Backward
+	cols, out, grad, colGrad, colsT, wT, gradW *lab.Matrix
+	batch                                      int
+}
+
+// NewConv2D returns a convolution with He-initialized weights.
+func NewConv2D(in Shape, filters, kernel, stride, padding, dilation int) *Conv2D {
+	fanIn := in.C * kernel * kernel
+	c := &Conv2D{
+		In:       in,
+		Filters:  filters,
+		Kernel:   kernel,
+		Stride:   stride,
+		Padding:  padding,
+		Dilation: dilation,
+		W:        lab.NewMatrix(filters, fanIn),
+		B:        lab.NewMatrix(filters, 1),
+	}
+	if out := c.OutShape(); out.H <= 0 || out.W <= 0 {
+		panic(fmt.Sprintf("nn: Conv2D kernel %d doesn't fit input %v", kernel, in))
+	}
+	HeNormal{}.Init(c.W, newRand())
+	c.ensureBuffers()
+	return c
+}
+
+func (c *Conv2D) Init(w, b Initializer, rng *rand.Rand) error {
+	return initAll(w, b, rng, []*lab.Matrix{c.W}, []*lab.Matrix{c.B})
+}
+
+func (c *Conv2D) stride() int {
+	if c.Stride <= 0 {
+		return 1
+	}
+	return c.Stride
+}
+
+func (c *Conv2D) dilation() int {
+	if c.Dilation <= 0 {
+		return 1
+	}
+	return c.Dilation
+}
+
+// OutShape is the shape of the convolution's output.
+func (c *Conv2D) OutShape() Shape {
+	span := c.dilation()*(c.Kernel-1) + 1
+	return Shape{
+		C: c.Filters,
+		H: (c.In.H+2*c.Padding-span)/c.stride() + 1,
+		W: (c.In.W+2*c.Padding-span)/c.stride() + 1,
+	}
+}
+
+func (c *Conv2D) ensureBuffers() {
+	if c.Wprime == nil {
+		c.Wprime = lab.NewMatrix(c.W.Rows, c.W.Cols)
+		c.Bprime = lab.NewMatrix(c.B.Rows, c.B.Cols)
+	}
+}
+
+func (c *Conv2D) Params() []*Param {
+	c.ensureBuffers()
+	return []*Param{
+		{Name: "W", Value: c.W, Grad: c.Wprime},
+		{Name: "B", Value: c.B, Grad: c.Bprime},
+	}
+}
+
+// patches calls f for every kernel tap of every output position, with the
+// row of the tap in the unrolled patch matrix, the output position and the
+// index of the input element under the tap, or -1 where it falls on padding.
+func (c *Conv2D) patches(f func(row, pos, in int)) {
+	out := c.OutShape()
+	s, d, k := c.stride(), c.dilation(), c.Kernel
+	for ch := 0; ch < c.In.C; ch++ {
+		for ki := 0; ki < k; ki++ {
+			for kj := 0; kj < k; kj++ {
+				row := (ch*k+ki)*k + kj
+				for oh := 0; oh < out.H; oh++ {
+					h := oh*s - c.Padding + ki*d
+					for ow := 0; ow < out.W; ow++ {
+						w := ow*s - c.Padding + kj*d
+						in := -1
+						if h >= 0 && h < c.In.H && w >= 0 && w < c.In.W {
+							in = (ch*c.In.H+h)*c.In.W + w
+						}
+						f(row, oh*out.W+ow, in)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (c *Conv2D) Forward(matrix *lab.Matrix) *lab.Matrix {
+	checkShape("Conv2D", c.In, matrix)
+	out := c.OutShape()
+	positions := out.H * out.W
+	n := matrix.Cols
+	c.batch = n
+
+	// Unroll the patches into a (C*K*K) x (batch*positions) matrix so the
+	// convolution becomes a single matrix product.
+	c.cols = lab.Ensure(c.cols, c.W.Cols, n*positions)
+	c.patches(func(row, pos, in int) {
+		dst := c.cols.X[row*c.cols.Cols+pos:]
+		for j := 0; j < n; j++ {
+			if in < 0 {
+				dst[j*positions] = 0
+			} else {
+				dst[j*positions] = matrix.X[in*n+j]
+			}
+		}
+	})
+	c.out = lab.MultiplyInto(lab.Ensure(c.out, c.Filters, n*positions), c.W, c.cols)
+
+	ret := lab.NewMatrix(out.Size(), n)
+	for f := 0; f < c.Filters; f++ {
+		b := c.B.X[f]
+		for j := 0; j < n; j++ {
+			src := c.out.X[f*c.out.Cols+j*positions:]
+			for p := 0; p < positions; p++ {
+				ret.X[(f*positions+p)*n+j] = src[p] + b
+			}
+		}
+	}
+	return ret
+}
+
+func (c *Conv2D) Backward(matrix *lab.Matrix) *lab.Matrix {
+	c.ensureBuffers()
+	out := c.OutShape()
+	positions := out.H * out.W
+	n := c.batch
+
+	c.grad = lab.Ensure(c.grad, c.Filters, n*positions)
+	for f := 0; f < c.Filters; f++ {
+		for j := 0; j < n; j++ {
+			dst := c.grad.X[f*c.grad.Cols+j*positions:]
+			for p := 0; p < positions; p++ {
+				g := matrix.X[(f*positions+p)*n+j]
+				dst[p] = g
+				c.Bprime.X[f] += g
+			}
+		}
+	}
+	c.colsT = lab.TransposeInto(lab.Ensure(c.colsT, c.cols.Cols, c.cols.Rows), c.cols)
+	c.gradW = lab.MultiplyInto(lab.Ensure(c.gradW, c.W.Rows, c.W.Cols), c.grad, c.colsT)
+	c.Wprime.AddScaled(1, c.gradW)
+
+	c.wT = lab.TransposeInto(lab.Ensure(c.wT, c.W.Cols, c.W.Rows), c.W)
+	c.colGrad = lab.MultiplyInto(lab.Ensure(c.colGrad, c.W.Cols, n*positions), c.wT, c.grad)
+	ret := lab.NewMatrix(c.In.Size(), n)
+	c.patches(func(row, pos, in int) {
+		if in < 0 {
+			return
+		}
+		src := c.colGrad.X[row*c.colGrad.Cols+pos:]
+		for j := 0; j < n; j++ {
+			ret.X[in*n+j] += src[j*positions]
+		}
+	})
+	return ret
+}
+
+// Update takes a plain gradient step and clears the gradients.
+func (c *Conv2D) Update(rate float64) {
+	sgdUpdate(c, rate)
+}
+
+// pool is the geometry shared by the pooling layers: a Size x Size window
+// moved by Stride, which defaults to Size, over inputs of shape In.
+type pool struct {
+	In     Shape
+	Size   int
+	Stride int
+}
+
+func (p pool) OutShape() Shape {
+	s := p.Stride
+	if s <= 0 {
+		s = p.Size
+	}
+	return Shape{C: p.In.C, H: (p.In.H-p.Size)/s + 1, W: (p.In.W-p.Size)/s + 1}
+}
+
+// check panics unless the window and stride are valid and fit the input.
+func (p pool) check(name string) {
+	if p.Size <= 0 || p.Stride < 0 {
+		panic(fmt.Sprintf("nn: %s needs a positive window and a non-negative stride, got %d and %d", name, p.Size, p.Stride))
+	}
+	if out := p.OutShape(); out.H <= 0 || out.W <= 0 {
+		panic(fmt.Sprintf("nn: %s window %d doesn't fit input %v", name, p.Size, p.In))
+	}
+}
+
+// windows calls f with the output index and the input indices of every
+// pooling window.
+func (p pool) windows(f func(out int, in []int)) {
+	s := p.Stride
+	if s <= 0 {
+		s = p.Size
+	}
+	o := p.OutShape()
+	in := make([]int, 0, p.Size*p.Size)
+	for c := 0; c < o.C; c++ {
+		for oh := 0; oh < o.H; oh++ {
+			for ow := 0; ow < o.W; ow++ {
+				in = in[:0]
+				for i := 0; i < p.Size; i++ {
+					for j := 0; j < p.Size; j++ {
+						in = append(in, (c*p.In.H+oh*s+i)*p.In.W+ow*s+j)
+					}
+				}
+				f((c*o.H+oh)*o.W+ow, in)
+			}
+		}
+	}
+}
+
+// MaxPool2D takes the maximum of every Size x Size window of each channel.
+type MaxPool2D struct {
+	In     Shape
+	Size   int
+	Stride int
+
+	// argmax is the input row of the maximum for every output element
+	argmax []int
+	batch  int
+}
+
+func NewMaxPool2D(in Shape, size, stride int) *MaxPool2D {
+	pool{in, size, stride}.check("MaxPool2D")
+	return &MaxPool2D{In: in, Size: size, Stride: stride}
+}
+
+func (m *MaxPool2D) OutShape() Shape {
+	return pool{m.In, m.Size, m.Stride}.OutShape()
+}
+
+func (m *MaxPool2D) Forward(matrix *lab.Matrix) *lab.Matrix {
+	checkShape("MaxPool2D", m.In, matrix)
+	n := matrix.Cols
+	m.batch = n
+	ret := lab.NewMatrix(m.OutShape().Size(), n)
+	if cap(m.argmax) < len(ret.X) {
+		m.argmax = make([]int, len(ret.X))
+	}
+	m.argmax = m.argmax[:len(ret.X)]
+	pool{m.In, m.Size, m.Stride}.windows(func(out int, in []int) {
+		for j := 0; j < n; j++ {
+			best := in[0]
+			for _, i := range in[1:] {
+				if matrix.X[i*n+j] > matrix.X[best*n+j] {
+					best = i
+				}
+			}
+			ret.X[out*n+j] = matrix.X[best*n+j]
+			m.argmax[out*n+j] = best
+		}
+	})
+	return ret
+}
+
+func (m *MaxPool2D) Backward(matrix *lab.Matrix) *lab.Matrix {
+	n := m.batch
+	ret := lab.NewMatrix(m.In.Size(), n)
+	for k, g := range matrix.X {
+		ret.X[m.argmax[k]*n+k%n] += g
+	}
+	return ret
+}
+
+func (m *MaxPool2D) Update(float64) {
+}
+
+// AvgPool2D averages every Size x Size window of each channel.
+type AvgPool2D struct {
+	In     Shape
+	Size   int
+	Stride int
+	batch  int
+}
+
+func NewAvgPool2D(in Shape, size, stride int) *AvgPool2D {
+	pool{in, size, stride}.check("AvgPool2D")
+	return &AvgPool2D{In: in, Size: size, Stride: stride}
+}
+
+func (a *AvgPool2D) OutShape() Shape {
+	return pool{a.In, a.Size, a.Stride}.OutShape()
+}
+
+func (a *AvgPool2D) Forward(matrix *lab.Matrix) *lab.Matrix {
+	checkShape("AvgPool2D", a.In, matrix)
+	n := matrix.Cols
+	a.batch = n
+	ret := lab.NewMatrix(a.OutShape().Size(), n)
+	scale := 1 / float64(a.Size*a.Size)
+	pool{a.In, a.Size, a.Stride}.windows(func(out int, in []int) {
+		for j := 0; j < n; j++ {
+			var sum float64
+			for _, i := range in {
+				sum += matrix.X[i*n+j]
+			}
+			ret.X[out*n+j] = sum * scale
+		}
+	})
+	return ret
+}
+
+func (a *AvgPool2D) Backward(matrix *lab.Matrix) *lab.Matrix {
+	n := a.batch
+	ret := lab.NewMatrix(a.In.Size(), n)
+	scale := 1 / float64(a.Size*a.Size)
+	pool{a.In, a.Size, a.Stride}.windows(func(out int, in []int) {
+		for j := 0; j < n; j++ {
+			g := matrix.X[out*n+j] * scale
+			for _, i := range in {
+				ret.X[i*n+j] += g
+			}
+		}
+	})
+	return ret
+}
+
+func (a *AvgPool2D) Update(float64) {
+}
+
+// Reshape reinterprets samples of shape In as shape Out. Samples are already
+// stored flat, so only the sizes are checked and the data passes through.
+type Reshape struct {
+	In  Shape
+	Out Shape
+}
+
+func NewReshape(in, out Shape) *Reshape {
+	if in.Size() != out.Size() {
+		panic(fmt.Sprintf("nn: can't reshape %v to %v", in, out))
+	}
+	return &Reshape{In: in, Out: out}
+}
+
+func (r *Reshape) Forward(matrix *lab.Matrix) *lab.Matrix {
+	checkShape("Reshape", r.In, matrix)
+	return matrix
+}
+
+func (r *Reshape) Backward(matrix *lab.Matrix) *lab.Matrix {
+	checkShape("Reshape", r.Out, matrix)
+	return matrix
+}
+
+func (r *Reshape) Update(float64) {
+}
+
+// Flatten turns samples of shape In into plain feature vectors for FCLayer.
+type Flatten struct {
+	In Shape
+}
+
+func (f *Flatten) Forward(matrix *lab.Matrix) *lab.Matrix {
+	checkShape("Flatten", f.In, matrix)
+	return matrix
+}
+
+func (f *Flatten) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return matrix
+}
+
+func (f *Flatten) Update(float64) {
+}
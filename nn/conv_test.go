@@ -0,0 +1,144 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// checkGradients compares the input and parameter gradients of layer under
+// the loss sum(r .* layer.Forward(x)) with central differences.
+func checkGradients(t *testing.T, name string, layer Layer, x *lab.Matrix, rng *rand.Rand) {
+	t.Helper()
+	out := layer.Forward(x)
+	r := lab.GaussianRand(out.Rows, out.Cols, rng)
+	loss := func() float64 {
+		var sum float64
+		for i, v := range layer.Forward(x).X {
+			sum += v * r.X[i]
+		}
+		return sum
+	}
+	var params []*Param
+	if p, ok := layer.(Parameterized); ok {
+		params = p.Params()
+		ZeroGrad(params)
+	}
+	layer.Forward(x)
+	grads := map[string]*lab.Matrix{"input": layer.Backward(r)}
+	values := map[string]*lab.Matrix{"input": x}
+	for _, p := range params {
+		grads[p.Name], values[p.Name] = p.Grad, p.Value
+	}
+	const h = 1e-5
+	for n, v := range values {
+		for i := range v.X {
+			old := v.X[i]
+			v.X[i] = old + h
+			up := loss()
+			v.X[i] = old - h
+			down := loss()
+			v.X[i] = old
+			want := (up - down) / (2 * h)
+			if got := grads[n].X[i]; math.Abs(got-want) > 1e-6*math.Max(1, math.Abs(want)) {
+				t.Errorf("%s: d/d%s[%d] = %v, want %v", name, n, i, got, want)
+				return
+			}
+		}
+	}
+}
+
+func TestSpatialGradients(t *testing.T) {
+	in := Shape{C: 2, H: 7, W: 6}
+	rng := rand.New(rand.NewSource(1))
+	x := lab.GaussianRand(in.Size(), 3, rng)
+	checkGradients(t, "conv", NewConv2D(in, 3, 3, 1, 1, 1), x, rng)
+	checkGradients(t, "strided conv", NewConv2D(in, 2, 2, 2, 1, 2), x, rng)
+	checkGradients(t, "max pool", NewMaxPool2D(in, 2, 2), x, rng)
+	checkGradients(t, "overlapping avg pool", NewAvgPool2D(in, 3, 1), x, rng)
+}
+
+func TestConv2D(t *testing.T) {
+	// A 1x3x3 input convolved with a single 2x2 kernel of ones sums every
+	// window; padding and dilation change which windows those are.
+	x := lab.NewVector([]float64{1, 2, 3, 4, 5, 6, 7, 8, 9}).Col()
+	cases := []struct {
+		stride, padding, dilation int
+		want                      []float64
+	}{
+		{1, 0, 1, []float64{12, 16, 24, 28}},
+		{2, 1, 1, []float64{1, 5, 11, 28}},
+		{1, 0, 2, []float64{20}},
+	}
+	for _, c := range cases {
+		conv := NewConv2D(Shape{1, 3, 3}, 1, 2, c.stride, c.padding, c.dilation)
+		conv.W = lab.Solid(1, 4, 1)
+		conv.B = lab.Solid(1, 1, 0)
+		got := conv.Forward(x)
+		if got.Rows != conv.OutShape().Size() || len(got.X) != len(c.want) {
+			t.Errorf("%+v: got %d outputs, want %d", c, len(got.X), len(c.want))
+			continue
+		}
+		for i := range c.want {
+			if got.X[i] != c.want[i] {
+				t.Errorf("%+v: got %v, want %v", c, got.X, c.want)
+				break
+			}
+		}
+	}
+	pooled := NewMaxPool2D(Shape{1, 3, 3}, 2, 1).Forward(x)
+	if pooled.X[0] != 5 || pooled.X[3] != 9 {
+		t.Errorf("max pool got %v", pooled.X)
+	}
+}
+
+func TestPoolGeometry(t *testing.T) {
+	panics := func(f func()) (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		f()
+		return false
+	}
+	in := Shape{C: 1, H: 4, W: 4}
+	for _, c := range [][2]int{{0, 1}, {-1, 1}, {2, -1}, {5, 1}} {
+		if !panics(func() { NewMaxPool2D(in, c[0], c[1]) }) || !panics(func() { NewAvgPool2D(in, c[0], c[1]) }) {
+			t.Errorf("window %d stride %d on %v didn't panic", c[0], c[1], in)
+		}
+	}
+	if panics(func() { NewMaxPool2D(in, 4, 0) }) {
+		t.Error("a window the size of the input panicked")
+	}
+}
+
+func TestSaveLoadLeNet(t *testing.T) {
+	in := Shape{1, 8, 8}
+	conv := NewConv2D(in, 2, 3, 1, 1, 1)
+	pool := NewMaxPool2D(conv.OutShape(), 2, 0)
+	model := &Network{Layers: []Layer{
+		NewReshape(Shape{64, 1, 1}, in),
+		conv,
+		&RELU{},
+		pool,
+		NewAvgPool2D(pool.OutShape(), 2, 0),
+		&Flatten{Shape{2, 2, 2}},
+		NewFCLayer(8, 3),
+	}}
+	fname := filepath.Join(t.TempDir(), "lenet.json")
+	if err := model.SaveModel(fname); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadModel(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := lab.Gaussian(64, 2)
+	want, got := model.Forward(x), loaded.Forward(x)
+	for i := range want.X {
+		if want.X[i] != got.X[i] {
+			t.Fatalf("output %d: got %v, want %v", i, got.X[i], want.X[i])
+		}
+	}
+	loaded.Backward(got)
+	loaded.Update(.1)
+}
@@ -0,0 +1,68 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/autograd"
+	"github.com/wizgrao/ml/lab"
+)
+
+// Func is a layer whose forward pass is an autograd computation F of the
+// input and the values of Weights, in order. Backward differentiates the
+// recorded computation, so only the forward pass has to be written. Func
+// layers hold code and can't be saved with SaveModel.
+type Func struct {
+	F       func(x *autograd.Var, params []*autograd.Var) *autograd.Var
+	Weights []*Param
+
+	tape *autograd.Tape
+	in   *autograd.Var
+	vars []*autograd.Var
+	out  *autograd.Var
+}
+
+// NewParam returns a parameter named name with value m and a zero gradient.
+func NewParam(name string, m *lab.Matrix) *Param {
+	return &Param{Name: name, Value: m, Grad: lab.NewMatrix(m.Rows, m.Cols)}
+}
+
+func NewFunc(f func(x *autograd.Var, params []*autograd.Var) *autograd.Var, params ...*Param) *Func {
+	return &Func{F: f, Weights: params}
+}
+
+func (f *Func) Params() []*Param {
+	return f.Weights
+}
+
+func (f *Func) Forward(matrix *lab.Matrix) *lab.Matrix {
+	if f.tape == nil {
+		f.tape = autograd.NewTape()
+	}
+	f.tape.Reset()
+	f.in = f.tape.Var(matrix)
+	f.vars = f.vars[:0]
+	for _, p := range f.Weights {
+		f.vars = append(f.vars, f.tape.Var(p.Value))
+	}
+	f.out = f.F(f.in, f.vars)
+	return f.out.Value
+}
+
+func (f *Func) Backward(matrix *lab.Matrix) *lab.Matrix {
+	f.tape.BackwardWith(f.out, matrix)
+	for i, v := range f.vars {
+		if v.Grad != nil {
+			f.Weights[i].Grad.AddScaled(1, v.Grad)
+			v.Grad = nil
+		}
+	}
+	ret := f.in.Grad
+	f.in.Grad = nil
+	if ret == nil {
+		ret = lab.NewMatrix(f.in.Value.Rows, f.in.Value.Cols)
+	}
+	return ret
+}
+
+// Update takes a plain gradient step and clears the gradients.
+func (f *Func) Update(rate float64) {
+	sgdUpdate(f, rate)
+}
@@ -0,0 +1,56 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/autograd"
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"testing"
+)
+
+func TestFuncMatchesFCLayer(t *testing.T) {
+	fc := NewFCLayer(4, 3)
+	fn := NewFunc(func(x *autograd.Var, p []*autograd.Var) *autograd.Var {
+		return autograd.AddCol(autograd.MatMul(p[0], x), p[1])
+	}, NewParam("W", fc.W.Scale(1)), NewParam("B", fc.B.Scale(1)))
+
+	x, g := lab.Gaussian(4, 5), lab.Gaussian(3, 5)
+	want, got := fc.Forward(x), fn.Forward(x)
+	wantIn, gotIn := fc.Backward(g), fn.Backward(g)
+	for _, c := range []struct {
+		name      string
+		want, got *lab.Matrix
+	}{
+		{"output", want, got},
+		{"input gradient", wantIn, gotIn},
+		{"W gradient", fc.Params()[0].Grad, fn.Params()[0].Grad},
+		{"B gradient", fc.Params()[1].Grad, fn.Params()[1].Grad},
+	} {
+		for i := range c.want.X {
+			if math.Abs(c.want.X[i]-c.got.X[i]) > 1e-9 {
+				t.Fatalf("%s %d: got %v, want %v", c.name, i, c.got.X[i], c.want.X[i])
+			}
+		}
+	}
+}
+
+func TestFuncLearnsAffine(t *testing.T) {
+	// A learnable version of Scale followed by Translate fits y = 3x - 1.
+	affine := NewFunc(func(x *autograd.Var, p []*autograd.Var) *autograd.Var {
+		return autograd.AddCol(autograd.MulCol(x, p[0]), p[1])
+	}, NewParam("S", lab.Solid(1, 1, 1)), NewParam("T", lab.NewMatrix(1, 1)))
+	model := &Network{Layers: []Layer{affine}}
+	x := lab.NewVector([]float64{-1, 0, 1, 2}).Row()
+	loss := &SELoss{Target: x.Scale(3).Add(lab.Solid(1, 4, -1))}
+	opt := NewAdam()
+	for i := 0; i < 2000; i++ {
+		loss.Reset()
+		loss.Loss(model.Forward(x))
+		model.Backward(loss.Backward())
+		opt.Step(model.Params(), .05)
+		ZeroGrad(model.Params())
+	}
+	s, tr := affine.Weights[0].Value.X[0], affine.Weights[1].Value.X[0]
+	if math.Abs(s-3) > .05 || math.Abs(tr+1) > .05 {
+		t.Errorf("learned scale %v, shift %v; want 3, -1", s, tr)
+	}
+}
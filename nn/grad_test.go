@@ -0,0 +1,160 @@
+package nn_test
+
+import (
+	"github.com/wizgrao/ml/autograd"
+	"github.com/wizgrao/ml/lab"
+	"github.com/wizgrao/ml/nn"
+	"github.com/wizgrao/ml/nn/nngrad"
+	"math/rand"
+	"testing"
+)
+
+const tol = 1e-5
+
+func TestLayerGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	img := nn.Shape{C: 2, H: 5, W: 5}
+	cases := []struct {
+		name  string
+		layer nn.Layer
+		in    int
+	}{
+		{"FCLayer", nn.NewFCLayer(4, 3), 4},
+		{"Sigmoid", &nn.Sigmoid{}, 4},
+		{"TanhActivation", &nn.TanhActivation{}, 4},
+		{"RELU", &nn.RELU{}, 4},
+		{"LeakyReLU", &nn.LeakyReLU{Slope: .2}, 4},
+		{"PReLU", prelu(rng), 4},
+		{"channel PReLU", nn.NewPReLU(img.C), img.Size()},
+		{"ELU", &nn.ELU{Alpha: .7}, 4},
+		{"SELU", &nn.SELU{}, 4},
+		{"GELU", &nn.GELU{}, 4},
+		{"Swish", &nn.Swish{}, 4},
+		{"Swish beta 2", &nn.Swish{Beta: 2}, 4},
+		{"Softplus", &nn.Softplus{Beta: 2}, 4},
+		{"Mish", &nn.Mish{}, 4},
+		{"HardTanh", &nn.HardTanh{Min: -.5, Max: .5}, 4},
+		{"Scale", &nn.Scale{S: -2}, 4},
+		{"Translate", &nn.Translate{V: lab.GaussianRand(4, 1, rng)}, 4},
+		{"Reparam", nn.NewReparam(2), 4},
+		{"Dropout", &nn.Dropout{P: .3}, 4},
+		{"Conv2D", nn.NewConv2D(img, 3, 3, 1, 1, 1), img.Size()},
+		{"strided, dilated Conv2D", nn.NewConv2D(img, 2, 2, 2, 1, 2), img.Size()},
+		{"MaxPool2D", nn.NewMaxPool2D(img, 2, 2), img.Size()},
+		{"overlapping MaxPool2D", nn.NewMaxPool2D(img, 3, 1), img.Size()},
+		{"AvgPool2D", nn.NewAvgPool2D(img, 2, 2), img.Size()},
+		{"Reshape", nn.NewReshape(img, nn.Shape{C: 1, H: 10, W: 5}), img.Size()},
+		{"Flatten", &nn.Flatten{In: img}, img.Size()},
+		{"Func", nn.NewFunc(func(x *autograd.Var, p []*autograd.Var) *autograd.Var {
+			return autograd.Tanh(autograd.MatMul(p[0], x))
+		}, nn.NewParam("W", lab.GaussianRand(2, 4, rng))), 4},
+		{"RNN", nn.NewRNN(3, 4), 3 * 5},
+		{"LSTM", nn.NewLSTM(3, 4), 3 * 5},
+		{"GRU", nn.NewGRU(3, 4), 3 * 5},
+		{"TimeDistributed", nn.NewTimeDistributed(3, nn.NewFCLayer(3, 2), &nn.Sigmoid{}), 3 * 4},
+		{"LayerNorm", &nn.LayerNorm{Dim: 4, Eps: 1e-5, Gain: lab.GaussianRand(4, 1, rng), Bias: lab.GaussianRand(4, 1, rng)}, 4 * 3},
+		{"Softmax", &nn.Softmax{}, 4},
+		{"LogSoftmax", &nn.LogSoftmax{}, 4},
+		{"PositionalEncoding", &nn.PositionalEncoding{Dim: 4}, 4 * 3},
+		{"MultiHeadAttention", nn.NewMultiHeadAttention(4, 2, false), 4 * 3},
+		{"causal MultiHeadAttention", nn.NewMultiHeadAttention(4, 2, true), 4 * 3},
+		{"TransformerBlock", nn.NewTransformerBlock(4, 2, 6, true), 4 * 3},
+		{"BatchNorm1D", batchNorm1D(false, rng), 4},
+		{"BatchNorm1D in eval mode", batchNorm1D(true, rng), 4},
+		{"BatchNorm2D", nn.NewBatchNorm2D(img), img.Size()},
+		{"Network", &nn.Network{Layers: []nn.Layer{
+			nn.NewFCLayer(4, 6), &nn.Sigmoid{}, nn.NewFCLayer(6, 4), nn.NewReparam(2),
+		}}, 4},
+	}
+	for _, c := range cases {
+		nngrad.CheckLayer(t, c.name, c.layer, lab.GaussianRand(c.in, 3, rng), tol)
+	}
+}
+
+func TestLossGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	nngrad.CheckLoss(t, "NormalKL", nn.NewNormalKL(3), lab.GaussianRand(6, 2, rng), tol)
+
+	ce := nn.NewSoftMaxCrossEntropy(4)
+	ce.SetTarget(lab.NewVector([]float64{0, 3, 1}).Row())
+	nngrad.CheckLoss(t, "SoftMaxCrossEntropy", ce, lab.GaussianRand(4, 3, rng), tol)
+	ce.Smoothing, ce.Weights = .1, []float64{1, 2, .5, 3}
+	nngrad.CheckLoss(t, "smoothed, weighted SoftMaxCrossEntropy", ce, lab.GaussianRand(4, 3, rng), tol)
+	ce.SetTarget((&nn.Softmax{}).Forward(lab.GaussianRand(4, 3, rng)))
+	nngrad.CheckLoss(t, "soft target SoftMaxCrossEntropy", ce, lab.GaussianRand(4, 3, rng), tol)
+
+	nll := nn.NewNLLLoss(4)
+	nll.Weights = []float64{1, 2, .5, 3}
+	nll.SetTarget(lab.NewVector([]float64{2, 0, 3}).Row())
+	nngrad.CheckLoss(t, "NLLLoss", nll, lab.GaussianRand(4, 3, rng), tol)
+
+	// BinaryLogProbLoss takes probabilities, so keep its input inside (0, 1).
+	probs := lab.GaussianRand(5, 2, rng)
+	for i, x := range probs.X {
+		probs.X[i] = .5 + .4*x/(1+abs(x))
+	}
+	bce := nn.NewBinaryLogProbLoss(5)
+	bce.SetTarget(&lab.Matrix{X: []float64{0, 1, 1, 0, 1, .5, 0, 0, 1, .2}, Rows: 5, Cols: 2})
+	nngrad.CheckLoss(t, "BinaryLogProbLoss", bce, probs, tol)
+
+	labels := &lab.Matrix{X: []float64{0, 1, 1, 0, 1, .5, 0, 0, 1, .2}, Rows: 5, Cols: 2}
+	for _, c := range []struct {
+		name   string
+		loss   nn.Loss
+		target *lab.Matrix
+	}{
+		{"MSE", &nn.MSE{}, lab.GaussianRand(5, 2, rng)},
+		{"MAE", &nn.MAE{}, lab.GaussianRand(5, 2, rng)},
+		{"Huber", &nn.Huber{Delta: .5}, lab.GaussianRand(5, 2, rng)},
+		{"SmoothL1", &nn.SmoothL1{Beta: .5}, lab.GaussianRand(5, 2, rng)},
+		{"LogCosh", &nn.LogCosh{}, lab.GaussianRand(5, 2, rng)},
+		{"BCEWithLogits", &nn.BCEWithLogits{}, labels},
+	} {
+		c.loss.(nn.Targeted).SetTarget(c.target)
+		nngrad.CheckLoss(t, c.name, c.loss, lab.GaussianRand(5, 2, rng), tol)
+	}
+	sum := &nn.MSE{}
+	sum.Reduction = nn.ReduceSum
+	sum.SetTarget(lab.GaussianRand(5, 2, rng))
+	nngrad.CheckLoss(t, "summed MSE", sum, lab.GaussianRand(5, 2, rng), tol)
+}
+
+func prelu(rng *rand.Rand) *nn.PReLU {
+	p := nn.NewPReLU(4)
+	p.Alpha = lab.GaussianRand(4, 1, rng)
+	return p
+}
+
+func batchNorm1D(eval bool, rng *rand.Rand) *nn.BatchNorm1D {
+	b := nn.NewBatchNorm1D(4)
+	b.Gain, b.Bias = lab.GaussianRand(4, 1, rng), lab.GaussianRand(4, 1, rng)
+	b.RunningMean, b.RunningVar = lab.GaussianRand(4, 1, rng), lab.Solid(4, 1, 2)
+	b.SetTraining(!eval)
+	return b
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func TestCheckerFindsBadGradient(t *testing.T) {
+	// Scale's Backward is right; doubling the gradient must be caught.
+	bad := nn.NewFunc(func(x *autograd.Var, _ []*autograd.Var) *autograd.Var {
+		return autograd.Scale(x, 2)
+	})
+	elems := (&nngrad.Checker{}).Layer(&doubled{bad}, lab.GaussianRand(3, 2, rand.New(rand.NewSource(1))))
+	if w := nngrad.Worst(elems); w.RelErr < .3 {
+		t.Errorf("worst relative error %v for a doubled gradient", w)
+	}
+}
+
+type doubled struct {
+	nn.Layer
+}
+
+func (d *doubled) Backward(m *lab.Matrix) *lab.Matrix {
+	return d.Layer.Backward(m).Scale(2)
+}
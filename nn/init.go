@@ -0,0 +1,306 @@
+package nn
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Initializer sets the values of a parameter matrix. Weight matrices map
+// inputs to outputs, so their fan-in is the number of columns and their
+// fan-out the number of rows. Random initializers draw only from rng.
+type Initializer interface {
+	Init(m *lab.Matrix, rng *rand.Rand) error
+}
+
+// Initializable is implemented by layers whose weights and biases can be
+// reinitialized. A nil Initializer leaves the matching parameters as they are.
+type Initializable interface {
+	Init(w, b Initializer, rng *rand.Rand) error
+}
+
+func fans(m *lab.Matrix) (in, out float64) {
+	return float64(m.Cols), float64(m.Rows)
+}
+
+func fillUniform(m *lab.Matrix, limit float64, rng *rand.Rand) {
+	for i := range m.X {
+		m.X[i] = limit * (2*rng.Float64() - 1)
+	}
+}
+
+func fillNormal(m *lab.Matrix, std float64, rng *rand.Rand) {
+	for i := range m.X {
+		m.X[i] = std * rng.NormFloat64()
+	}
+}
+
+// XavierUniform (Glorot) draws from U(-a, a) with a = sqrt(6/(fanIn+fanOut)),
+// keeping the variance of activations and gradients for tanh and sigmoid.
+type XavierUniform struct{}
+
+func (XavierUniform) Init(m *lab.Matrix, rng *rand.Rand) error {
+	in, out := fans(m)
+	fillUniform(m, math.Sqrt(6/(in+out)), rng)
+	return nil
+}
+
+// XavierNormal (Glorot) draws from N(0, 2/(fanIn+fanOut)).
+type XavierNormal struct{}
+
+func (XavierNormal) Init(m *lab.Matrix, rng *rand.Rand) error {
+	in, out := fans(m)
+	fillNormal(m, math.Sqrt(2/(in+out)), rng)
+	return nil
+}
+
+// HeUniform (Kaiming) draws from U(-a, a) with a = sqrt(6/fanIn), for layers
+// followed by a RELU.
+type HeUniform struct{}
+
+func (HeUniform) Init(m *lab.Matrix, rng *rand.Rand) error {
+	in, _ := fans(m)
+	fillUniform(m, math.Sqrt(6/in), rng)
+	return nil
+}
+
+// HeNormal (Kaiming) draws from N(0, 2/fanIn).
+type HeNormal struct{}
+
+func (HeNormal) Init(m *lab.Matrix, rng *rand.Rand) error {
+	in, _ := fans(m)
+	fillNormal(m, math.Sqrt(2/in), rng)
+	return nil
+}
+
+// LeCunUniform draws from U(-a, a) with a = sqrt(3/fanIn).
+type LeCunUniform struct{}
+
+func (LeCunUniform) Init(m *lab.Matrix, rng *rand.Rand) error {
+	in, _ := fans(m)
+	fillUniform(m, math.Sqrt(3/in), rng)
+	return nil
+}
+
+// LeCunNormal draws from N(0, 1/fanIn).
+type LeCunNormal struct{}
+
+func (LeCunNormal) Init(m *lab.Matrix, rng *rand.Rand) error {
+	in, _ := fans(m)
+	fillNormal(m, math.Sqrt(1/in), rng)
+	return nil
+}
+
+// Orthogonal sets m to a random matrix with orthonormal rows or columns,
+// whichever there are fewer of, scaled by Gain (1 if zero).
+type Orthogonal struct {
+	Gain float64
+}
+
+func (o Orthogonal) Init(m *lab.Matrix, rng *rand.Rand) error {
+	gain := o.Gain
+	if gain == 0 {
+		gain = 1
+	}
+	// Orthonormalize the rows of a short, wide matrix with Gram-Schmidt,
+	// transposing first if m is tall.
+	rows, cols := m.Rows, m.Cols
+	if rows > cols {
+		rows, cols = cols, rows
+	}
+	a := lab.NewMatrix(rows, cols)
+	for i := 0; i < rows; {
+		row := a.X[i*cols : (i+1)*cols]
+		for k := range row {
+			row[k] = rng.NormFloat64()
+		}
+		for j := 0; j < i; j++ {
+			prev := a.X[j*cols : (j+1)*cols]
+			var dot float64
+			for k := range row {
+				dot += row[k] * prev[k]
+			}
+			for k := range row {
+				row[k] -= dot * prev[k]
+			}
+		}
+		var norm float64
+		for _, v := range row {
+			norm += v * v
+		}
+		if norm = math.Sqrt(norm); norm < 1e-6 {
+			// Nearly in the span of the previous rows; draw again.
+			continue
+		}
+		for k := range row {
+			row[k] /= norm
+		}
+		i++
+	}
+	a = a.Scale(gain)
+	if m.Rows > m.Cols {
+		a = a.Transpose()
+	}
+	copy(m.X, a.X)
+	return nil
+}
+
+// ConstantInit sets every value to V.
+type ConstantInit struct {
+	V float64
+}
+
+func (c ConstantInit) Init(m *lab.Matrix, _ *rand.Rand) error {
+	for i := range m.X {
+		m.X[i] = c.V
+	}
+	return nil
+}
+
+// LoadParams copies parameter values from the file at path, a JSON object
+// that maps parameter names, as given by p.Params(), to lab.Matrix values of
+// the same shape. Parameters missing from the file keep their values; names
+// that p doesn't have are an error.
+func LoadParams(p Parameterized, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]*lab.Matrix
+	if err := json.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("nn: reading %s: %v", path, err)
+	}
+	params := map[string]*Param{}
+	for _, param := range p.Params() {
+		params[param.Name] = param
+	}
+	for name, src := range values {
+		param, ok := params[name]
+		if !ok {
+			return fmt.Errorf("nn: %s holds unknown parameter %q", path, name)
+		}
+		m := param.Value
+		if src == nil || src.Rows != m.Rows || src.Cols != m.Cols || len(src.X) != len(m.X) {
+			return fmt.Errorf("nn: %s holds a bad value for %s, want a %dx%d matrix", path, name, m.Rows, m.Cols)
+		}
+		copy(m.X, src.X)
+	}
+	return nil
+}
+
+// SaveParams writes the parameters of p to path in the format LoadParams
+// reads.
+func SaveParams(p Parameterized, path string) error {
+	values := map[string]*lab.Matrix{}
+	for _, param := range p.Params() {
+		values[param.Name] = param.Value
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+var initializers = map[string]func(arg string) (Initializer, error){}
+
+// RegisterInitializer makes an initializer available to NewInitializer under
+// name. f builds it from the text after the colon in "name:arg", which is
+// empty if there is none.
+func RegisterInitializer(name string, f func(arg string) (Initializer, error)) {
+	initializers[name] = f
+}
+
+func plain(i Initializer) func(string) (Initializer, error) {
+	return func(string) (Initializer, error) {
+		return i, nil
+	}
+}
+
+func init() {
+	RegisterInitializer("xavier_uniform", plain(XavierUniform{}))
+	RegisterInitializer("glorot_uniform", plain(XavierUniform{}))
+	RegisterInitializer("xavier_normal", plain(XavierNormal{}))
+	RegisterInitializer("glorot_normal", plain(XavierNormal{}))
+	RegisterInitializer("he_uniform", plain(HeUniform{}))
+	RegisterInitializer("kaiming_uniform", plain(HeUniform{}))
+	RegisterInitializer("he_normal", plain(HeNormal{}))
+	RegisterInitializer("kaiming_normal", plain(HeNormal{}))
+	RegisterInitializer("lecun_uniform", plain(LeCunUniform{}))
+	RegisterInitializer("lecun_normal", plain(LeCunNormal{}))
+	RegisterInitializer("zeros", plain(ConstantInit{}))
+	RegisterInitializer("ones", plain(ConstantInit{V: 1}))
+	RegisterInitializer("orthogonal", func(arg string) (Initializer, error) {
+		if arg == "" {
+			return Orthogonal{}, nil
+		}
+		gain, err := strconv.ParseFloat(arg, 64)
+		return Orthogonal{Gain: gain}, err
+	})
+	RegisterInitializer("constant", func(arg string) (Initializer, error) {
+		v, err := strconv.ParseFloat(arg, 64)
+		return ConstantInit{V: v}, err
+	})
+}
+
+// NewInitializer returns a registered initializer from a spec of the form
+// name or name:arg: xavier_uniform, xavier_normal, he_uniform, he_normal,
+// lecun_uniform, lecun_normal, orthogonal[:gain], zeros, ones or constant:v.
+// Glorot and Kaiming are accepted for Xavier and He.
+func NewInitializer(spec string) (Initializer, error) {
+	name, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+	f, ok := initializers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("nn: unknown initializer %q, want one of %s", name, strings.Join(Initializers(), ", "))
+	}
+	i, err := f(arg)
+	if err != nil {
+		return nil, fmt.Errorf("nn: initializer %q: %v", spec, err)
+	}
+	return i, nil
+}
+
+// Initializers lists the registered initializer names.
+func Initializers() []string {
+	var names []string
+	for name := range initializers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// initAll runs w on the weights and b on the biases, skipping a nil
+// Initializer.
+func initAll(w, b Initializer, rng *rand.Rand, weights, biases []*lab.Matrix) error {
+	for _, step := range []struct {
+		init Initializer
+		ms   []*lab.Matrix
+	}{{w, weights}, {b, biases}} {
+		if step.init == nil {
+			continue
+		}
+		for _, m := range step.ms {
+			if err := step.init.Init(m, rng); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newRand returns the generator constructors draw initial weights from. It
+// is seeded from math/rand's global source, so the weights differ between
+// runs; Init with a seeded generator makes them reproducible.
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
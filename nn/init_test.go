@@ -0,0 +1,135 @@
+package nn
+
+import (
+	"encoding/json"
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitializerScale(t *testing.T) {
+	cases := []struct {
+		spec     string
+		variance float64
+	}{
+		// 400 inputs, 100 outputs
+		{"xavier_uniform", 2. / 500},
+		{"glorot_normal", 2. / 500},
+		{"he_uniform", 2. / 400},
+		{"kaiming_normal", 2. / 400},
+		{"lecun_uniform", 1. / 400},
+		{"lecun_normal", 1. / 400},
+	}
+	for _, c := range cases {
+		ini, err := NewInitializer(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := lab.NewMatrix(100, 400)
+		if err := ini.Init(m, rand.New(rand.NewSource(1))); err != nil {
+			t.Fatal(err)
+		}
+		var sq float64
+		for _, v := range m.X {
+			sq += v * v
+		}
+		if v := sq / float64(len(m.X)); math.Abs(v/c.variance-1) > .05 {
+			t.Errorf("%s: variance %v, want %v", c.spec, v, c.variance)
+		}
+	}
+}
+
+func TestOrthogonal(t *testing.T) {
+	for _, shape := range [][2]int{{3, 7}, {7, 3}, {5, 5}} {
+		m := lab.NewMatrix(shape[0], shape[1])
+		Orthogonal{Gain: 2}.Init(m, rand.New(rand.NewSource(1)))
+		// The smaller of m mT and mT m is 4 I.
+		p := m.Multiply(m.Transpose())
+		if m.Rows > m.Cols {
+			p = m.Transpose().Multiply(m)
+		}
+		for i := 0; i < p.Rows; i++ {
+			for j := 0; j < p.Cols; j++ {
+				want := 0.0
+				if i == j {
+					want = 4
+				}
+				if math.Abs(p.Access(i, j)-want) > 1e-9 {
+					t.Fatalf("%dx%d: product %d,%d is %v, want %v", m.Rows, m.Cols, i, j, p.Access(i, j), want)
+				}
+			}
+		}
+	}
+}
+
+func TestInitReproducible(t *testing.T) {
+	build := func(seed int64) *Network {
+		net := &Network{Layers: []Layer{
+			NewFCLayer(4, 6),
+			&RELU{},
+			&Network{Layers: []Layer{NewFCLayer(6, 2)}},
+		}}
+		if err := net.Init(HeNormal{}, ConstantInit{V: .1}, rand.New(rand.NewSource(seed))); err != nil {
+			t.Fatal(err)
+		}
+		return net
+	}
+	a, b, c := build(1), build(1), build(2)
+	for i, p := range a.Params() {
+		if !equal(p.Value, b.Params()[i].Value) {
+			t.Errorf("%s differs between runs with the same seed", p.Name)
+		}
+		if p.Name[len(p.Name)-1] == 'W' && equal(p.Value, c.Params()[i].Value) {
+			t.Errorf("%s is the same for different seeds", p.Name)
+		}
+	}
+	inner := a.Layers[2].(*Network).Layers[0].(*FCLayer)
+	if inner.B.X[0] != .1 {
+		t.Errorf("nested bias %v, want .1", inner.B.X[0])
+	}
+
+	// The LSTM keeps its forget gate bias of 1; the other gates get b.
+	lstm := NewLSTM(2, 3)
+	if err := lstm.Init(HeNormal{}, ConstantInit{}, rand.New(rand.NewSource(1))); err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{0, 0, 0, 1, 1, 1, 0, 0, 0, 0, 0, 0}; !equal(lstm.B, lab.NewVector(want).Col()) {
+		t.Errorf("LSTM bias %v, want %v", lstm.B.X, want)
+	}
+}
+
+func TestLoadParams(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "w.json")
+	// Two weight shapes, 3x2 and 1x3.
+	src := &Network{Layers: []Layer{NewFCLayer(2, 3), &Sigmoid{}, NewFCLayer(3, 1)}}
+	if err := SaveParams(src, fname); err != nil {
+		t.Fatal(err)
+	}
+	dst := &Network{Layers: []Layer{NewFCLayer(2, 3), &Sigmoid{}, NewFCLayer(3, 1)}}
+	if err := LoadParams(dst, fname); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range dst.Params() {
+		if want := src.Params()[i]; !equal(p.Value, want.Value) {
+			t.Errorf("%s: got %v, want %v", p.Name, p.Value, want.Value)
+		}
+	}
+	if err := LoadParams(&Network{Layers: []Layer{NewFCLayer(2, 2)}}, fname); err == nil {
+		t.Error("loaded parameters into a network of another shape")
+	}
+	b, _ := json.Marshal(map[string]*lab.Matrix{"0.W": lab.NewMatrix(3, 2), "9.W": lab.NewMatrix(1, 1)})
+	if err := os.WriteFile(fname, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadParams(dst, fname); err == nil {
+		t.Error("loaded an unknown parameter")
+	}
+	for _, spec := range []string{"bogus", "constant:x", "file:w.json"} {
+		if _, err := NewInitializer(spec); err == nil {
+			t.Errorf("NewInitializer(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+)
+
+// Reduction says how a loss combines the terms of a batch into the batch
+// loss. The terms are the elements of the output for losses that score every
+// element, and the columns for losses that score a whole sample, such as
+// SoftMaxCrossEntropy, NLLLoss and NormalKL. The zero value is ReduceMean.
+type Reduction int
+
+const (
+	// ReduceMean averages over the terms of the batch, so the loss doesn't
+	// grow with the batch or output size.
+	ReduceMean Reduction = iota
+	// ReduceSum adds up every term.
+	ReduceSum
+)
+
+// scale is the weight of each of n terms.
+func (r Reduction) scale(n int) float64 {
+	if r == ReduceMean {
+		return 1 / float64(n)
+	}
+	return 1
+}
+
+func targetsMismatch(m *lab.Matrix) string {
+	return fmt.Sprintf("nn: loss targets don't match %dx%d outputs", m.Rows, m.Cols)
+}
+
+// elementLoss is the shared base of the losses that score every output
+// element against the target element at the same position. As with every
+// Loss, each call to Loss adds the reduced batch loss to a running total.
+type elementLoss struct {
+	Target    *lab.Matrix
+	Reduction Reduction
+
+	total     float64
+	gradients *lab.Matrix
+}
+
+func (e *elementLoss) SetTarget(y *lab.Matrix) {
+	e.Target = y
+}
+
+func (e *elementLoss) Reset() {
+	e.total = 0
+	e.gradients = nil
+}
+
+func (e *elementLoss) Backward() *lab.Matrix {
+	return e.gradients
+}
+
+// loss reduces f over the elements of matrix, where f gives the loss of an
+// output x with target y and its derivative with respect to x.
+func (e *elementLoss) loss(matrix *lab.Matrix, f func(x, y float64) (float64, float64)) float64 {
+	if e.Target == nil || e.Target.Rows != matrix.Rows || e.Target.Cols != matrix.Cols {
+		panic(targetsMismatch(matrix))
+	}
+	scale := e.Reduction.scale(len(matrix.X))
+	e.gradients = lab.NewMatrix(matrix.Rows, matrix.Cols)
+	var sum float64
+	for i, x := range matrix.X {
+		l, g := f(x, e.Target.X[i])
+		sum += l
+		e.gradients.X[i] = scale * g
+	}
+	e.total += scale * sum
+	return e.total
+}
+
+func sign(x float64) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+// MSE is the squared error (x - y)^2.
+type MSE struct {
+	elementLoss
+}
+
+func (m *MSE) Loss(matrix *lab.Matrix) float64 {
+	return m.loss(matrix, func(x, y float64) (float64, float64) {
+		d := x - y
+		return d * d, 2 * d
+	})
+}
+
+// SELoss is the old name of MSE. It keeps its own Target field, so literals
+// such as SELoss{Target: y} still work.
+type SELoss struct {
+	Target *lab.Matrix
+	MSE
+}
+
+func (s *SELoss) SetTarget(y *lab.Matrix) {
+	s.Target = y
+}
+
+func (s *SELoss) Loss(matrix *lab.Matrix) float64 {
+	s.MSE.Target = s.Target
+	return s.MSE.Loss(matrix)
+}
+
+// MAE is the absolute error |x - y|.
+type MAE struct {
+	elementLoss
+}
+
+func (m *MAE) Loss(matrix *lab.Matrix) float64 {
+	return m.loss(matrix, func(x, y float64) (float64, float64) {
+		return math.Abs(x - y), sign(x - y)
+	})
+}
+
+// Huber is quadratic, d^2/2, for errors d up to Delta and linear beyond, so
+// outliers pull with a bounded gradient. A zero Delta means 1.
+type Huber struct {
+	Delta float64
+	elementLoss
+}
+
+func (h *Huber) Loss(matrix *lab.Matrix) float64 {
+	delta := h.Delta
+	if delta == 0 {
+		delta = 1
+	}
+	return h.loss(matrix, func(x, y float64) (float64, float64) {
+		d := x - y
+		if math.Abs(d) <= delta {
+			return d * d / 2, d
+		}
+		return delta * (math.Abs(d) - delta/2), delta * sign(d)
+	})
+}
+
+// SmoothL1 is d^2/(2 Beta) for errors d below Beta and |d| - Beta/2 beyond:
+// Huber divided by Beta, so its linear part has unit slope. A zero Beta means
+// 1.
+type SmoothL1 struct {
+	Beta float64
+	elementLoss
+}
+
+func (s *SmoothL1) Loss(matrix *lab.Matrix) float64 {
+	beta := s.Beta
+	if beta == 0 {
+		beta = 1
+	}
+	return s.loss(matrix, func(x, y float64) (float64, float64) {
+		d := x - y
+		if math.Abs(d) < beta {
+			return d * d / (2 * beta), d / beta
+		}
+		return math.Abs(d) - beta/2, sign(d)
+	})
+}
+
+// LogCosh is log(cosh(x - y)), close to d^2/2 for small errors d and to |d|
+// for large ones.
+type LogCosh struct {
+	elementLoss
+}
+
+func (l *LogCosh) Loss(matrix *lab.Matrix) float64 {
+	return l.loss(matrix, func(x, y float64) (float64, float64) {
+		d := math.Abs(x - y)
+		// log(cosh(d)) = d + log(1 + e^-2d) - log 2 without overflowing cosh
+		return d + math.Log1p(math.Exp(-2*d)) - math.Ln2, math.Tanh(x - y)
+	})
+}
+
+// BCEWithLogits is the binary cross-entropy of independent labels, such as
+// the tags of a multi-label task, computed from scores before the sigmoid.
+// Working on the scores keeps it finite where a saturated sigmoid would give
+// log(0). Target holds one probability per score.
+type BCEWithLogits struct {
+	elementLoss
+}
+
+func (b *BCEWithLogits) Loss(matrix *lab.Matrix) float64 {
+	return b.loss(matrix, func(z, y float64) (float64, float64) {
+		// -y log sigmoid(z) - (1-y) log(1 - sigmoid(z)) = softplus(z) - y z
+		return softplus(z) - y*z, sigmoid(z) - y
+	})
+}
+
+// probEps keeps the probabilities given to BinaryLogProbLoss away from 0 and
+// 1, where the log and its gradient blow up.
+const probEps = 1e-12
+
+// BinaryLogProbLoss is the binary cross-entropy of probabilities, such as the
+// output of a Sigmoid, reduced over every element. Probabilities within 1e-12
+// of 0 or 1 are clamped, so a saturated sigmoid gives a large but finite loss
+// and gradient.
+// Prefer BCEWithLogits on the scores before the sigmoid where possible.
+type BinaryLogProbLoss struct {
+	Target *lab.Matrix
+	Back   *lab.Matrix
+	Len    int
+	L      float64
+
+	Reduction Reduction
+}
+
+func NewBinaryLogProbLoss(len int) *BinaryLogProbLoss {
+	return &BinaryLogProbLoss{
+		Target: lab.NewMatrix(len, 1),
+		Back:   lab.NewMatrix(len, 1),
+		Len:    len,
+	}
+}
+
+func (b *BinaryLogProbLoss) Loss(matrix *lab.Matrix) float64 {
+	if b.Target.Rows != matrix.Rows || b.Target.Cols != matrix.Cols {
+		panic(targetsMismatch(matrix))
+	}
+	scale := b.Reduction.scale(len(matrix.X))
+	b.Back = lab.Ensure(b.Back, matrix.Rows, matrix.Cols)
+	for i, yi := range matrix.X {
+		xi := b.Target.X[i]
+		p := math.Min(math.Max(yi, probEps), 1-probEps)
+		b.L -= scale * (xi*math.Log(p) + (1-xi)*math.Log(1-p))
+		b.Back.X[i] = scale * (-xi/p + (1-xi)/(1-p))
+	}
+	return b.L
+}
+
+func (b *BinaryLogProbLoss) SetTarget(y *lab.Matrix) {
+	b.Target = y
+}
+
+func (b *BinaryLogProbLoss) Reset() {
+	b.Back = lab.NewMatrix(b.Len, 1)
+	b.L = 0
+}
+
+func (b *BinaryLogProbLoss) Backward() *lab.Matrix {
+	return b.Back
+}
@@ -0,0 +1,128 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"testing"
+)
+
+func TestLossValues(t *testing.T) {
+	x := lab.NewVector([]float64{0, .5, 3, -2}).Col()
+	zero := lab.NewMatrix(4, 1)
+	logCosh := (math.Log(math.Cosh(.5)) + math.Log(math.Cosh(3)) + math.Log(math.Cosh(2))) / 4
+	cases := []struct {
+		name string
+		loss interface {
+			Loss
+			Targeted
+		}
+		want float64
+	}{
+		{"MSE", &MSE{}, (.25 + 9 + 4) / 4},
+		{"MAE", &MAE{}, (.5 + 3 + 2) / 4},
+		{"Huber", &Huber{}, (.125 + 2.5 + 1.5) / 4},
+		{"Huber delta 4", &Huber{Delta: 4}, (.25 + 9 + 4) / 8},
+		{"SmoothL1", &SmoothL1{}, (.125 + 2.5 + 1.5) / 4},
+		{"SmoothL1 beta 2", &SmoothL1{Beta: 2}, (.0625 + 2 + 1) / 4},
+		{"LogCosh", &LogCosh{}, logCosh},
+		{"BCEWithLogits", &BCEWithLogits{}, (math.Ln2 + math.Log1p(math.Exp(.5)) + math.Log1p(math.Exp(3)) + math.Log1p(math.Exp(-2))) / 4},
+	}
+	for _, c := range cases {
+		c.loss.SetTarget(zero)
+		if got := c.loss.Loss(x); math.Abs(got-c.want) > 1e-12 {
+			t.Errorf("%s: loss %v, want %v", c.name, got, c.want)
+		}
+		// The running total adds the next batch.
+		if got := c.loss.Loss(x); math.Abs(got-2*c.want) > 1e-12 {
+			t.Errorf("%s: running total %v, want %v", c.name, got, 2*c.want)
+		}
+		c.loss.Reset()
+	}
+
+	sum := &MSE{}
+	sum.Reduction = ReduceSum
+	sum.SetTarget(zero)
+	if got := sum.Loss(x); got != 13.25 {
+		t.Errorf("summed MSE %v, want 13.25", got)
+	}
+	if g := sum.Backward(); g.X[2] != 6 {
+		t.Errorf("summed MSE gradient %v, want 6 at 3", g.X)
+	}
+}
+
+func TestSELossDoesNotCancel(t *testing.T) {
+	loss := &SELoss{}
+	loss.SetTarget(lab.NewMatrix(1, 2))
+	if got := loss.Loss(lab.NewVector([]float64{1, -1}).Row()); got != 1 {
+		t.Errorf("opposite errors gave loss %v, want 1", got)
+	}
+}
+
+func TestBCEWithLogitsSaturated(t *testing.T) {
+	b := &BCEWithLogits{}
+	b.Reduction = ReduceSum
+	b.SetTarget(lab.NewVector([]float64{1, 1, 0, .5}).Col())
+	got := b.Loss(lab.NewVector([]float64{-1000, 1000, 0, 0}).Col())
+	if want := 1000 + 2*math.Ln2; math.Abs(got-want) > 1e-9 {
+		t.Errorf("loss %v, want %v", got, want)
+	}
+	want := []float64{-1, 0, .5, 0}
+	for i, g := range b.Backward().X {
+		if math.Abs(g-want[i]) > 1e-12 {
+			t.Errorf("gradient %v, want %v", b.Backward().X, want)
+			break
+		}
+	}
+}
+
+func TestLossesStayFinite(t *testing.T) {
+	huge := lab.NewVector([]float64{-1000, 1000}).Col()
+	target := lab.NewVector([]float64{1, 0}).Col()
+	losses := map[string]Loss{"LogCosh": &LogCosh{}, "BCEWithLogits": &BCEWithLogits{}}
+	for name, l := range losses {
+		l.(Targeted).SetTarget(target)
+		if v := l.Loss(huge); math.IsInf(v, 0) || math.IsNaN(v) || math.Abs(v-1000) > 1 {
+			t.Errorf("%s of saturated scores is %v, want about 1000", name, v)
+		}
+	}
+
+	// A saturated sigmoid puts out exact 0s and 1s.
+	b := NewBinaryLogProbLoss(2)
+	b.SetTarget(target)
+	v := b.Loss(lab.NewVector([]float64{0, 1}).Col())
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		t.Errorf("BinaryLogProbLoss of saturated probabilities is %v", v)
+	}
+	// Both outputs are wrong, so they must still be pushed back.
+	if g := b.Backward().X; g[0] >= 0 || g[1] <= 0 || math.IsInf(g[0], 0) || math.IsInf(g[1], 0) {
+		t.Errorf("BinaryLogProbLoss gradient %v at clamped probabilities, want finite and pushing back", g)
+	}
+}
+
+func TestReductionsAgree(t *testing.T) {
+	x := &lab.Matrix{X: []float64{.2, .7, .4, .9}, Rows: 2, Cols: 2}
+	classes := lab.NewVector([]float64{1, 0}).Row()
+	build := func(r Reduction) map[string]Loss {
+		ce := NewSoftMaxCrossEntropy(2)
+		ce.SetTarget(classes)
+		nll := NewNLLLoss(2)
+		nll.SetTarget(classes)
+		bce := NewBinaryLogProbLoss(2)
+		bce.SetTarget(lab.Solid(2, 2, 1))
+		kl := NewNormalKL(1)
+		ce.Reduction, nll.Reduction, bce.Reduction, kl.Reduction = r, r, r, r
+		return map[string]Loss{"SoftMaxCrossEntropy": ce, "NLLLoss": nll, "BinaryLogProbLoss": bce, "NormalKL": kl}
+	}
+	// Two columns, and four elements for BinaryLogProbLoss.
+	terms := map[string]float64{"SoftMaxCrossEntropy": 2, "NLLLoss": 2, "BinaryLogProbLoss": 4, "NormalKL": 2}
+	mean, sum := build(ReduceMean), build(ReduceSum)
+	for name, l := range mean {
+		m, s := l.Loss(x), sum[name].Loss(x)
+		if math.Abs(s-terms[name]*m) > 1e-12 {
+			t.Errorf("%s: sum %v, mean %v over %v terms", name, s, m, terms[name])
+		}
+		if g, h := l.Backward().X[0], sum[name].Backward().X[0]; math.Abs(h-terms[name]*g) > 1e-12 {
+			t.Errorf("%s: summed gradient %v, mean gradient %v", name, h, g)
+		}
+	}
+}
@@ -1,60 +1,118 @@
 package nn
 
 import (
-	"encoding/json"
 	"github.com/wizgrao/ml/lab"
 	"math"
-	"os"
+	"math/rand"
+	"strconv"
 )
 
+// Layer is one stage of a network. Forward and Backward work on a batch stored
+// as a features x batch matrix, one sample per column.
 type Layer interface {
 	Forward(*lab.Matrix) *lab.Matrix
 	Backward(*lab.Matrix) *lab.Matrix
 	Update(rate float64)
 }
 
+// Switchable is implemented by layers that behave differently while training
+// and at inference, such as Dropout and BatchNorm. Layers start out training.
+type Switchable interface {
+	SetTraining(training bool)
+}
+
+// Stochastic is implemented by layers that draw random numbers, each from its
+// own generator. Seed replaces the generator with one seeded with seed, so
+// the layer's noise can be reproduced. A layer that was never seeded seeds
+// itself from math/rand's global source on first use.
+type Stochastic interface {
+	Seed(seed int64)
+}
+
+// ensureRand returns *r, first creating it if it is nil.
+func ensureRand(r **rand.Rand) *rand.Rand {
+	if *r == nil {
+		*r = newRand()
+	}
+	return *r
+}
+
 // Reparam implements the reparameterization trick
 // First n elements represent the standard deviations
 // Next n elements represent mean
+// Each column of the input is sampled independently
+// At inference it returns the mean without sampling
 type Reparam struct {
-	Eps *lab.Matrix
-	N   int
-	Sig *lab.Matrix
+	Eps  *lab.Matrix `json:"-"`
+	N    int
+	Sig  *lab.Matrix `json:"-"`
+	Rand *rand.Rand  `json:"-"`
+
+	eval bool
 }
 
 func NewReparam(n int) *Reparam {
 	return &Reparam{
-		Eps: lab.Gaussian(n, 1),
+		Eps: lab.NewMatrix(n, 1),
 		N:   n,
 	}
 }
 
 func (r *Reparam) Forward(mat *lab.Matrix) *lab.Matrix {
-	r.Eps = lab.Gaussian(r.N, 1)
-	sigma := mat.SubMatrix(0, 0, r.N, 1)
-	mu := mat.SubMatrix(r.N, 0, r.N, 1)
-	r.Sig = sigma
-	return sigma.Exp().MultElems(r.Eps).Add(mu)
+	if r.eval {
+		r.Eps = lab.NewMatrix(r.N, mat.Cols)
+	} else {
+		r.Eps = lab.GaussianRand(r.N, mat.Cols, ensureRand(&r.Rand))
+	}
+	r.Sig = lab.Ensure(r.Sig, r.N, mat.Cols)
+	n := r.N * mat.Cols
+	copy(r.Sig.X, mat.X[:n])
+	out := lab.NewMatrix(r.N, mat.Cols)
+	for i, sig := range r.Sig.X {
+		out.X[i] = math.Exp(sig)*r.Eps.X[i] + mat.X[n+i]
+	}
+	return out
 }
 
 func (r *Reparam) Backward(mat *lab.Matrix) *lab.Matrix {
-	return lab.VStack(r.Eps.MultElems(r.Sig.Exp()).MultElems(mat), mat)
-
+	ret := lab.NewMatrix(2*r.N, mat.Cols)
+	n := len(mat.X)
+	for i, g := range mat.X {
+		ret.X[i] = r.Eps.X[i] * math.Exp(r.Sig.X[i]) * g
+		ret.X[n+i] = g
+	}
+	return ret
 }
 
 func (r *Reparam) Update(float64) {
 }
 
+func (r *Reparam) SetTraining(training bool) {
+	r.eval = !training
+}
+
+func (r *Reparam) Seed(seed int64) {
+	r.Rand = rand.New(rand.NewSource(seed))
+}
+
+// Loss scores a features x batch matrix of network outputs. Loss adds the
+// batch to a running total, which it returns, and Backward gives the gradient
+// with respect to the matrix most recently passed to Loss. Reset clears both.
 type Loss interface {
 	Loss(*lab.Matrix) float64
 	Reset()
 	Backward() *lab.Matrix
 }
 
+// NormalKL is the KL divergence of N(mean, sd^2) from N(0, 1) for columns
+// holding N log standard deviations followed by N means, reduced over the
+// columns.
 type NormalKL struct {
 	KL        float64
 	N         int
 	Gradients *lab.Matrix
+
+	Reduction Reduction
 }
 
 func NewNormalKL(n int) *NormalKL {
@@ -67,19 +125,18 @@ func NewNormalKL(n int) *NormalKL {
 func (n *NormalKL) Loss(mat *lab.Matrix) float64 {
 	var newLoss float64
 
-	sigma := mat.SubMatrix(0, 0, n.N, 1)
-	mu := mat.SubMatrix(n.N, 0, n.N, 1)
-	dSigma := lab.NewMatrix(n.N, 1)
-
-	for i := 0; i < n.N; i++ {
-		sig := sigma.Access(i, 0)
-		m := mu.Access(i, 0)
-		newLoss -= 0.5 * (1.0 + sig*2 - m*m - math.Exp(sig*2))
-		dSigma.Set(i, 0, math.Min(.5, -1+math.Exp(sig)))
+	n.Gradients = lab.Ensure(n.Gradients, 2*n.N, mat.Cols)
+	scale := n.Reduction.scale(mat.Cols)
+	half := n.N * mat.Cols
+	for i := 0; i < half; i++ {
+		sig := mat.X[i]
+		m := mat.X[half+i]
+		newLoss -= scale * 0.5 * (1.0 + sig*2 - m*m - math.Exp(sig*2))
+		n.Gradients.X[i] = scale * (math.Exp(2*sig) - 1)
+		n.Gradients.X[half+i] = scale * m
 	}
 
 	n.KL += newLoss
-	n.Gradients = n.Gradients.Add(lab.VStack(dSigma, mu))
 	return n.KL
 }
 
@@ -92,150 +149,71 @@ func (n *NormalKL) Reset() {
 	n.KL = 0
 }
 
-type SoftMaxCrossEntropy struct {
-	crossEntropy float64
-	gradients    *lab.Matrix
-	size         int
-	Target       int
-}
-
-func NewSoftMaxCrossEntropy(size int) *SoftMaxCrossEntropy {
-	return &SoftMaxCrossEntropy{
-		size:      size,
-		gradients: lab.NewMatrix(size, 1),
-	}
-}
-
-func (s *SoftMaxCrossEntropy) Backward() *lab.Matrix {
-	return s.gradients
-}
-
-func (s *SoftMaxCrossEntropy) Reset() {
-	s.crossEntropy = 0
-	s.gradients = lab.NewMatrix(s.size, 1)
-}
-
-func (s *SoftMaxCrossEntropy) Loss(mat *lab.Matrix) float64 {
-	max := mat.Access(0, 0)
-	for i := 1; i < s.size; i++ {
-		if mat.Access(i, 0) > max {
-			max = mat.Access(i, 0)
-		}
-	}
-	mat = mat.Sub(lab.Solid(s.size, 1, max))
-	var denom float64
-	for i := 0; i < s.size; i++ {
-		denom += math.Exp(mat.Access(i, 0))
-	}
-	newGradients := lab.NewMatrix(s.size, 1)
-	for i := 0; i < s.size; i++ {
-
-		var y float64
-		p := math.Exp(mat.Access(i, 0)) / denom
-		if i == s.Target {
-			y = 1.0
-			s.crossEntropy += -math.Log(p)
-		}
-		newGradients.Set(i, 0, p-y)
-	}
-	s.gradients = s.gradients.Add(newGradients)
-	return s.crossEntropy
-}
-
 type FCLayer struct {
 	W *lab.Matrix
 	B *lab.Matrix
 
-	Wprime      *lab.Matrix
-	Bprime      *lab.Matrix
-	Input       *lab.Matrix
-	Activations *lab.Matrix
+	Wprime      *lab.Matrix `json:"-"`
+	Bprime      *lab.Matrix `json:"-"`
+	Input       *lab.Matrix `json:"-"`
+	Activations *lab.Matrix `json:"-"`
 
-	WMomentum *lab.Matrix
-	BMomentum *lab.Matrix
+	// scratch space reused by Backward
+	xT, wT, gradW *lab.Matrix
 }
 
+// NewFCLayer returns a layer with Xavier uniform weights and zero biases.
 func NewFCLayer(in, out int) *FCLayer {
-	return &FCLayer{
+	f := &FCLayer{
 		Wprime:      lab.NewMatrix(out, in),
 		Bprime:      lab.NewMatrix(out, 1),
-		WMomentum:   lab.NewMatrix(out, in),
-		BMomentum:   lab.NewMatrix(out, 1),
-		W:           lab.Gaussian(out, in).Scale(1.0 / 10.0),
+		W:           lab.NewMatrix(out, in),
 		B:           lab.NewMatrix(out, 1),
 		Input:       lab.NewMatrix(in, 1),
 		Activations: lab.NewMatrix(out, 1),
 	}
+	XavierUniform{}.Init(f.W, newRand())
+	return f
+}
+
+func (f *FCLayer) Init(w, b Initializer, rng *rand.Rand) error {
+	return initAll(w, b, rng, []*lab.Matrix{f.W}, []*lab.Matrix{f.B})
 }
 
 func (f *FCLayer) Forward(matrix *lab.Matrix) *lab.Matrix {
 	f.Input = matrix
-	out := f.W.Multiply(matrix).Add(f.B)
-	f.Activations = out
+	out := lab.MultiplyInto(lab.NewMatrix(f.W.Rows, matrix.Cols), f.W, matrix)
+	f.Activations = lab.AddColInto(out, out, f.B)
 	return out
 }
 
-func (f *FCLayer) Backward(matrix *lab.Matrix) *lab.Matrix {
-	f.Bprime = matrix.Add(f.Bprime)
-	for i := 0; i < f.Wprime.Rows; i++ {
-		for j := 0; j < f.Wprime.Cols; j++ {
-			f.Wprime.Set(i, j, math.Min(.5, math.Max(-.5, f.Input.Access(j, 0)*matrix.Access(i, 0)+f.Wprime.Access(i, j))))
-		}
-	}
-	return f.W.Transpose().Multiply(matrix)
-}
-
-func (f *FCLayer) Update(rate float64) {
-	f.WMomentum = f.WMomentum.Scale(.9).Add(f.Wprime.Scale(rate))
-	f.BMomentum = f.BMomentum.Scale(.9).Add(f.Bprime.Scale(rate))
-	f.W = f.W.Sub(f.WMomentum)
-	f.B = f.B.Sub(f.BMomentum)
-	f.Wprime = lab.NewMatrix(f.W.Rows, f.W.Cols)
-	f.Bprime = lab.NewMatrix(f.B.Rows, f.B.Cols)
-}
-
-type TanhActivation struct {
-	Input      *lab.Matrix
-	Activation *lab.Matrix
-}
-
-func (f *TanhActivation) Forward(matrix *lab.Matrix) *lab.Matrix {
-	ret := lab.NewMatrix(matrix.Rows, 1)
-	f.Input = matrix
-	for i, val := range matrix.X {
-		ret.X[i] = math.Tanh(val)
+// ensureBuffers allocates the gradient buffers, which are not part of the
+// saved model.
+func (f *FCLayer) ensureBuffers() {
+	if f.Wprime == nil {
+		f.Wprime = lab.NewMatrix(f.W.Rows, f.W.Cols)
+		f.Bprime = lab.NewMatrix(f.B.Rows, f.B.Cols)
 	}
-	f.Activation = ret
-	return ret
-}
-
-func (f *TanhActivation) Backward(matrix *lab.Matrix) *lab.Matrix {
-	return matrix.MultElems(lab.Solid(matrix.Rows, matrix.Cols, 1).Sub(f.Activation.MultElems(f.Activation)))
-}
-
-func (f *TanhActivation) Update(rate float64) {
 }
 
-type Sigmoid struct {
-	Input      *lab.Matrix
-	Activation *lab.Matrix
-}
-
-func (f *Sigmoid) Forward(matrix *lab.Matrix) *lab.Matrix {
-	ret := lab.NewMatrix(matrix.Rows, 1)
-	f.Input = matrix
-	for i, val := range matrix.X {
-		ret.X[i] = 1 / (1 + math.Exp(-1*val))
+func (f *FCLayer) Backward(matrix *lab.Matrix) *lab.Matrix {
+	f.ensureBuffers()
+	for i := 0; i < matrix.Rows; i++ {
+		for _, g := range matrix.X[i*matrix.Cols : (i+1)*matrix.Cols] {
+			f.Bprime.X[i] += g
+		}
 	}
-	f.Activation = ret
-	return ret
-}
-
-func (f *Sigmoid) Backward(matrix *lab.Matrix) *lab.Matrix {
-	return matrix.MultElems(lab.Solid(matrix.Rows, matrix.Cols, 1).Sub(f.Activation)).MultElems(f.Activation)
+	f.xT = lab.TransposeInto(lab.Ensure(f.xT, f.Input.Cols, f.Input.Rows), f.Input)
+	f.gradW = lab.MultiplyInto(lab.Ensure(f.gradW, f.W.Rows, f.W.Cols), matrix, f.xT)
+	f.Wprime.AddScaled(1, f.gradW)
+	f.wT = lab.TransposeInto(lab.Ensure(f.wT, f.W.Cols, f.W.Rows), f.W)
+	return lab.MultiplyInto(lab.NewMatrix(f.W.Cols, matrix.Cols), f.wT, matrix)
 }
 
-func (f *Sigmoid) Update(rate float64) {
+// Update takes a plain gradient step and clears the gradients. Use an
+// Optimizer on Params for anything more elaborate.
+func (f *FCLayer) Update(rate float64) {
+	sgdUpdate(f, rate)
 }
 
 type Scale struct {
@@ -254,13 +232,14 @@ func (f *Scale) Backward(matrix *lab.Matrix) *lab.Matrix {
 func (f *Scale) Update(rate float64) {
 }
 
+// Translate adds the column vector V to every sample.
 type Translate struct {
 	V *lab.Matrix
 }
 
 func (f *Translate) Forward(matrix *lab.Matrix) *lab.Matrix {
 
-	return matrix.Add(f.V)
+	return matrix.AddCol(f.V)
 }
 
 func (f *Translate) Backward(matrix *lab.Matrix) *lab.Matrix {
@@ -270,63 +249,6 @@ func (f *Translate) Backward(matrix *lab.Matrix) *lab.Matrix {
 func (f *Translate) Update(rate float64) {
 }
 
-type SELoss struct {
-	Target *lab.Matrix
-	Diff   *lab.Matrix
-	Ct     int
-}
-
-func (s *SELoss) Loss(matrix *lab.Matrix) float64 {
-	if s.Ct == 0 {
-		s.Diff = lab.NewMatrix(matrix.Rows, 1)
-	}
-	s.Ct++
-	s.Diff = s.Diff.Add(matrix.Sub(s.Target))
-	return s.Diff.Scale(1/float64(s.Ct)).Transpose().Multiply(s.Diff).Access(0, 0)
-}
-
-func (s *SELoss) Reset() {
-	s.Ct = 0
-}
-
-func (s *SELoss) Backward() *lab.Matrix {
-	return s.Diff.Scale(2 * 1 / float64(s.Ct))
-}
-
-type BinaryLogProbLoss struct {
-	Target *lab.Matrix
-	Back   *lab.Matrix
-	Len    int
-	L      float64
-}
-
-func NewBinaryLogProbLoss(len int) *BinaryLogProbLoss {
-	return &BinaryLogProbLoss{
-		Target: lab.NewMatrix(len, 1),
-		Back:   lab.NewMatrix(len, 1),
-		Len:    len,
-	}
-}
-
-func (b *BinaryLogProbLoss) Loss(matrix *lab.Matrix) float64 {
-	for i := 0; i < b.Len; i++ {
-		xi := b.Target.Access(i, 0)
-		yi := matrix.Access(i, 0)
-		b.L -= xi*math.Log(yi) + (1-xi)*math.Log(1-yi)
-		b.Back.Set(i, 0, math.Min(.5, math.Max(-.5, b.Back.Access(i, 0)-xi/yi+(1-xi)/(1-yi))))
-	}
-	return b.L
-}
-
-func (b *BinaryLogProbLoss) Reset() {
-	b.Back = lab.NewMatrix(b.Len, 1)
-	b.L = 0
-}
-
-func (b *BinaryLogProbLoss) Backward() *lab.Matrix {
-	return b.Back
-}
-
 type Network struct {
 	Layers []Layer
 }
@@ -350,53 +272,76 @@ func (n *Network) Update(rate float64) {
 		layer.Update(rate)
 	}
 }
-func (network *Network) SaveModel(fileName string) error {
-	f, err := os.Create(fileName)
-	defer f.Close()
-	if err != nil {
-		return err
+
+// Init initializes every layer of the network that is Initializable,
+// including nested networks, in order.
+func (n *Network) Init(w, b Initializer, rng *rand.Rand) error {
+	for _, layer := range n.Layers {
+		if l, ok := layer.(Initializable); ok {
+			if err := l.Init(w, b, rng); err != nil {
+				return err
+			}
+		}
 	}
-	encoder := json.NewEncoder(f)
-	return encoder.Encode(network)
+	return nil
 }
 
-func (network *Network) LoadModel(fileName string) error {
-	f, err := os.Open(fileName)
-	defer f.Close()
-	if err != nil {
-		return err
+// Seed seeds every Stochastic layer of the network, including those of nested
+// networks, with a seed derived from seed and the layer's position.
+func (n *Network) Seed(seed int64) {
+	for i, layer := range n.Layers {
+		if s, ok := layer.(Stochastic); ok {
+			s.Seed(lab.DeriveSeed(seed, strconv.Itoa(i)))
+		}
 	}
-	decoder := json.NewDecoder(f)
-	return decoder.Decode(network)
 }
 
-type RELU struct {
-	Input      *lab.Matrix
-	Activation *lab.Matrix
+// SetTraining switches every layer of the network, including those of nested
+// networks, between training and inference.
+func (n *Network) SetTraining(training bool) {
+	for _, layer := range n.Layers {
+		if s, ok := layer.(Switchable); ok {
+			s.SetTraining(training)
+		}
+	}
 }
 
-func (f *RELU) Forward(matrix *lab.Matrix) *lab.Matrix {
-	ret := lab.NewMatrix(matrix.Rows, 1)
-	f.Input = matrix
-	for i, val := range matrix.X {
-		ret.X[i] = math.Max(val, .1*val)
-	}
-	f.Activation = ret
-	return ret
+// Dropout zeroes each input with probability P while training and scales the
+// rest by 1/(1-P), so it passes its input through unchanged at inference.
+type Dropout struct {
+	P    float64
+	Rand *rand.Rand `json:"-"`
+
+	mask *lab.Matrix
+	eval bool
 }
 
-func (f *RELU) Backward(matrix *lab.Matrix) *lab.Matrix {
-	ret := lab.NewMatrix(matrix.Rows, 1)
-	f.Input = matrix
-	for i, val := range f.Activation.X {
-		if val >= 0 {
-			ret.X[i] = 1
-		} else {
-			ret.X[i] = .1
+func (d *Dropout) Forward(matrix *lab.Matrix) *lab.Matrix {
+	d.mask = lab.Solid(matrix.Rows, matrix.Cols, 1)
+	if !d.eval && d.P > 0 {
+		rng := ensureRand(&d.Rand)
+		for i := range d.mask.X {
+			if rng.Float64() < d.P {
+				d.mask.X[i] = 0
+			} else {
+				d.mask.X[i] = 1 / (1 - d.P)
+			}
 		}
 	}
-	return ret.MultElems(matrix)
+	return matrix.MultElems(d.mask)
+}
+
+func (d *Dropout) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return matrix.MultElems(d.mask)
+}
+
+func (d *Dropout) Update(rate float64) {
+}
+
+func (d *Dropout) SetTraining(training bool) {
+	d.eval = !training
 }
 
-func (f *RELU) Update(rate float64) {
+func (d *Dropout) Seed(seed int64) {
+	d.Rand = rand.New(rand.NewSource(seed))
 }
@@ -1,8 +1,13 @@
 package nn
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/wizgrao/ml/lab"
+	"io"
+	"math"
+	"path/filepath"
 	"testing"
 )
 
@@ -12,3 +17,317 @@ func TestNewFCLayer(t *testing.T) {
 	fmt.Println(a.B)
 	fmt.Println(lab.Gaussian(2, 2))
 }
+
+func TestSaveLoadModel(t *testing.T) {
+	encoder := &Network{
+		Layers: []Layer{
+			&Translate{lab.Solid(3, 1, -.5)},
+			NewFCLayer(3, 4),
+			&RELU{},
+			NewFCLayer(4, 4),
+		},
+	}
+	decoder := &Network{
+		Layers: []Layer{
+			NewFCLayer(2, 3),
+			&Sigmoid{},
+			&Scale{2},
+		},
+	}
+	model := &Network{Layers: []Layer{encoder, NewReparam(2), decoder}}
+
+	fname := filepath.Join(t.TempDir(), "model.json")
+	if err := model.SaveModel(fname); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadModel(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Layers) != 3 {
+		t.Fatalf("got %d layers, want 3", len(loaded.Layers))
+	}
+	enc, ok := loaded.Layers[0].(*Network)
+	if !ok {
+		t.Fatalf("layer 0 is %T, want *Network", loaded.Layers[0])
+	}
+	if _, ok := loaded.Layers[1].(*Reparam); !ok {
+		t.Fatalf("layer 1 is %T, want *Reparam", loaded.Layers[1])
+	}
+	dec, ok := loaded.Layers[2].(*Network)
+	if !ok {
+		t.Fatalf("layer 2 is %T, want *Network", loaded.Layers[2])
+	}
+
+	x := lab.Gaussian(3, 1)
+	want := encoder.Forward(x)
+	got := enc.Forward(x)
+	for i := range want.X {
+		if want.X[i] != got.X[i] {
+			t.Fatalf("encoder output %d: got %v, want %v", i, got.X[i], want.X[i])
+		}
+	}
+	z := lab.Gaussian(2, 1)
+	want = decoder.Forward(z)
+	got = dec.Forward(z)
+	for i := range want.X {
+		if want.X[i] != got.X[i] {
+			t.Fatalf("decoder output %d: got %v, want %v", i, got.X[i], want.X[i])
+		}
+	}
+
+	// Loaded layers must be trainable without further setup.
+	loaded.Backward(loaded.Forward(x))
+	loaded.Update(.1)
+}
+
+func TestLoadLegacyRELU(t *testing.T) {
+	// Files saved before RELU became a true ReLU keep its slope of .1.
+	var n Network
+	if err := json.Unmarshal([]byte(`{"Layers":[{"Type":"RELU","Layer":{}}]}`), &n); err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Forward(lab.Solid(1, 1, -1)).X[0]; got != -.1 {
+		t.Errorf("legacy RELU(-1) = %v, want -.1", got)
+	}
+	b, err := json.Marshal(&Network{Layers: []Layer{&RELU{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &n); err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Forward(lab.Solid(1, 1, -1)).X[0]; got != 0 {
+		t.Errorf("saved RELU(-1) = %v, want 0", got)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	model := &Network{
+		Layers: []Layer{
+			&Network{Layers: []Layer{NewFCLayer(3, 4), &RELU{}}},
+			NewFCLayer(4, 2),
+		},
+	}
+	opt := NewAdam()
+	x := lab.Gaussian(3, 1)
+	model.Backward(model.Forward(x))
+	opt.Step(model.Params(), .1)
+
+	var buf bytes.Buffer
+	sched := NewReduceOnPlateau(.1)
+	sched.Observe(1)
+	sched.Observe(2)
+	ckpt := &Checkpoint{Model: model, OptimizerState: opt.State(), ScheduleState: sched.State(), Epoch: 3, Step: 42, Seed: 7}
+	if err := ckpt.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadCheckpoint(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Epoch != 3 || loaded.Step != 42 || loaded.Seed != 7 {
+		t.Errorf("got epoch %d step %d seed %d, want 3 42 7", loaded.Epoch, loaded.Step, loaded.Seed)
+	}
+	want, got := model.Params(), loaded.Model.Params()
+	for i := range want {
+		for j := range want[i].Value.X {
+			if want[i].Value.X[j] != got[i].Value.X[j] {
+				t.Fatalf("%s[%d]: got %v, want %v", want[i].Name, j, got[i].Value.X[j], want[i].Value.X[j])
+			}
+		}
+	}
+	restoredSched := NewReduceOnPlateau(.1)
+	if err := restoredSched.SetState(loaded.ScheduleState); err != nil {
+		t.Fatal(err)
+	}
+	if restoredSched.best != 1 || restoredSched.bad != 1 {
+		t.Errorf("restored schedule: best %v bad %d, want 1 1", restoredSched.best, restoredSched.bad)
+	}
+	restored := NewAdam()
+	if err := restored.SetState(loaded.OptimizerState); err != nil {
+		t.Fatal(err)
+	}
+	for name, m := range opt.State() {
+		got := restored.State()[name]
+		if got == nil {
+			t.Fatalf("optimizer state %s missing after load", name)
+		}
+		for j := range m.X {
+			if m.X[j] != got.X[j] {
+				t.Fatalf("%s[%d]: got %v, want %v", name, j, got.X[j], m.X[j])
+			}
+		}
+	}
+
+	buf.Reset()
+	ckpt.Float32 = true
+	if err := ckpt.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if _, err := ReadCheckpoint(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 1
+	if _, err := ReadCheckpoint(bytes.NewReader(data)); err != ErrBadChecksum {
+		t.Errorf("corrupted checkpoint: got error %v, want %v", err, ErrBadChecksum)
+	}
+}
+
+func TestBatchMatchesColumns(t *testing.T) {
+	model := &Network{
+		Layers: []Layer{
+			&Translate{lab.Solid(3, 1, -.5)},
+			NewFCLayer(3, 4),
+			&RELU{},
+			NewFCLayer(4, 2),
+			&TanhActivation{},
+		},
+	}
+	batch := lab.Gaussian(3, 5)
+	out := model.Forward(batch)
+	if out.Rows != 2 || out.Cols != 5 {
+		t.Fatalf("got %dx%d output, want 2x5", out.Rows, out.Cols)
+	}
+	for j := 0; j < batch.Cols; j++ {
+		col := model.Forward(batch.SubMatrix(0, j, 3, 1))
+		for i := 0; i < 2; i++ {
+			if math.Abs(col.Access(i, 0)-out.Access(i, j)) > 1e-12 {
+				t.Errorf("sample %d output %d: batch %v, alone %v", j, i, out.Access(i, j), col.Access(i, 0))
+			}
+		}
+	}
+}
+
+func TestSetTraining(t *testing.T) {
+	dropout := &Dropout{P: .5}
+	reparam := NewReparam(2)
+	model := &Network{Layers: []Layer{
+		&Network{Layers: []Layer{NewTimeDistributed(2, dropout)}},
+		reparam,
+	}}
+	x := lab.Gaussian(4, 50)
+
+	out := dropout.Forward(x)
+	var zeros int
+	for i, v := range out.X {
+		if v == 0 {
+			zeros++
+		} else if math.Abs(v-2*x.X[i]) > 1e-12 {
+			t.Fatalf("kept input %d: got %v, want %v", i, v, 2*x.X[i])
+		}
+	}
+	if zeros < 50 || zeros > 150 {
+		t.Errorf("dropped %d of 200 inputs at P=.5", zeros)
+	}
+
+	model.SetTraining(false)
+	if out := dropout.Forward(x); !equal(out, x) {
+		t.Error("dropout changed its input at inference")
+	}
+	// At inference Reparam returns the means, the second half of each column.
+	want := x.SubMatrix(2, 0, 2, 50)
+	for i := 0; i < 2; i++ {
+		if out := model.Forward(x); !equal(out, want) {
+			t.Fatalf("inference pass %d: got %v, want the means %v", i, out, want)
+		}
+	}
+
+	model.SetTraining(true)
+	if out := model.Forward(x); equal(out, want) {
+		t.Error("training pass didn't sample")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	build := func(seed int64) *Network {
+		net := &Network{Layers: []Layer{
+			&Dropout{P: .5},
+			&Network{Layers: []Layer{&Dropout{P: .5}}},
+			NewReparam(4),
+		}}
+		net.Seed(seed)
+		return net
+	}
+	x := lab.Gaussian(8, 20)
+	a, b, c := build(1), build(1), build(2)
+	want := a.Forward(x)
+	if !equal(b.Forward(x), want) {
+		t.Error("same seed gave different noise")
+	}
+	if equal(c.Forward(x), want) {
+		t.Error("different seeds gave the same noise")
+	}
+	// Each layer gets its own stream.
+	outer, inner := a.Layers[0].(*Dropout), a.Layers[1].(*Network).Layers[0].(*Dropout)
+	if equal(outer.mask, inner.mask) {
+		t.Error("nested dropout layers drew the same mask")
+	}
+}
+
+func equal(a, b *lab.Matrix) bool {
+	if a.Rows != b.Rows || a.Cols != b.Cols {
+		return false
+	}
+	for i := range a.X {
+		if a.X[i] != b.X[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// BenchmarkMNISTStep runs one training step of the cmd/mnist model on a batch
+// of 10. Run with -benchmem to see the allocations per step.
+func BenchmarkMNISTStep(b *testing.B) {
+	model := &Network{
+		Layers: []Layer{
+			&Translate{lab.Solid(28*28, 1, -128.0)},
+			&Scale{1.0 / 128.0},
+			NewFCLayer(28*28, 100),
+			&RELU{},
+			NewFCLayer(100, 10),
+		},
+	}
+	loss := NewSoftMaxCrossEntropy(10)
+	x := lab.Gaussian(28*28, 10).Scale(64).AddCol(lab.Solid(28*28, 1, 128))
+	loss.Targets = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		loss.Reset()
+		loss.Loss(model.Forward(x))
+		model.Backward(loss.Backward())
+		model.Update(.00001)
+	}
+}
+
+// The model can keep running while a checkpoint of it is written, as it does
+// when a callback saves in the background.
+func TestCheckpointLeavesModel(t *testing.T) {
+	model := &Network{Layers: []Layer{NewFCLayer(3, 4), &RELU{}, NewFCLayer(4, 2)}}
+	ckpt := &Checkpoint{Model: model}
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := ckpt.Write(io.Discard); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	x := lab.Gaussian(3, 1)
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		default:
+			model.Forward(x)
+		}
+	}
+}
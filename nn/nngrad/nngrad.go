@@ -0,0 +1,182 @@
+// Package nngrad checks the hand-written gradients of nn layers and losses
+// against central finite differences.
+package nngrad
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"github.com/wizgrao/ml/nn"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// Element compares the analytic and numeric gradient of one element of an
+// input or parameter. Wrt is "input" or the parameter's name. RelErr is
+// |a-n| / (|a|+|n|) and AbsErr is |a-n|.
+type Element struct {
+	Wrt      string
+	Index    int
+	Analytic float64
+	Numeric  float64
+	RelErr   float64
+	AbsErr   float64
+}
+
+func (e Element) String() string {
+	return fmt.Sprintf("d/d%s[%d]: analytic %g, numeric %g, relative error %.3g, absolute error %.3g", e.Wrt, e.Index, e.Analytic, e.Numeric, e.RelErr, e.AbsErr)
+}
+
+// Checker holds the settings of a gradient check. The zero value uses a step
+// of 1e-6, an absolute tolerance of 1e-8 and seed 0.
+type Checker struct {
+	// H is the finite difference step.
+	H float64
+	// AbsTol is the absolute error below which an element passes whatever
+	// its relative error, since finite differences of tiny gradients are
+	// dominated by rounding noise.
+	AbsTol float64
+	// Seed reseeds layers that are nn.Stochastic, such as Reparam, before
+	// every forward pass so they draw the same noise each time. It also
+	// seeds the weights that a layer's output is reduced with.
+	Seed int64
+}
+
+func (c *Checker) h() float64 {
+	if c.H == 0 {
+		return 1e-6
+	}
+	return c.H
+}
+
+func (c *Checker) absTol() float64 {
+	if c.AbsTol == 0 {
+		return 1e-8
+	}
+	return c.AbsTol
+}
+
+// relErr is |a-n| / (|a|+|n|), or 0 when both are 0.
+func relErr(a, n float64) float64 {
+	return math.Abs(a-n) / math.Max(math.Abs(a)+math.Abs(n), math.SmallestNonzeroFloat64)
+}
+
+// check compares grads, the analytic gradients of f with respect to the
+// matrices in wrt, with finite differences of f.
+func (c *Checker) check(f func() float64, wrt map[string]*lab.Matrix, grads map[string]*lab.Matrix) []Element {
+	h := c.h()
+	var elems []Element
+	for _, name := range sortedNames(wrt) {
+		m, g := wrt[name], grads[name]
+		for i := range m.X {
+			old := m.X[i]
+			m.X[i] = old + h
+			up := f()
+			m.X[i] = old - h
+			down := f()
+			m.X[i] = old
+			n := (up - down) / (2 * h)
+			elems = append(elems, Element{Wrt: name, Index: i, Analytic: g.X[i], Numeric: n, RelErr: relErr(g.X[i], n), AbsErr: math.Abs(g.X[i] - n)})
+		}
+	}
+	return elems
+}
+
+// Layer checks the gradients of layer at input x, with respect to x and
+// every parameter, for the scalar sum(r .* layer.Forward(x)) with random r.
+// Parameter gradients are cleared before and after.
+func (c *Checker) Layer(layer nn.Layer, x *lab.Matrix) []Element {
+	forward := func() *lab.Matrix {
+		if s, ok := layer.(nn.Stochastic); ok {
+			s.Seed(c.Seed)
+		}
+		return layer.Forward(x)
+	}
+	out := forward()
+	r := lab.GaussianRand(out.Rows, out.Cols, rand.New(rand.NewSource(c.Seed)))
+	f := func() float64 {
+		var sum float64
+		for i, v := range forward().X {
+			sum += v * r.X[i]
+		}
+		return sum
+	}
+
+	var params []*nn.Param
+	if p, ok := layer.(nn.Parameterized); ok {
+		params = p.Params()
+	}
+	nn.ZeroGrad(params)
+	forward()
+	wrt := map[string]*lab.Matrix{"input": x}
+	grads := map[string]*lab.Matrix{"input": copyOf(layer.Backward(r))}
+	for _, p := range params {
+		wrt[p.Name], grads[p.Name] = p.Value, copyOf(p.Grad)
+	}
+	nn.ZeroGrad(params)
+	return c.check(f, wrt, grads)
+}
+
+// Loss checks the gradient of loss with respect to its input x. Targeted
+// losses must have their target set beforehand.
+func (c *Checker) Loss(loss nn.Loss, x *lab.Matrix) []Element {
+	f := func() float64 {
+		loss.Reset()
+		return loss.Loss(x)
+	}
+	f()
+	grads := map[string]*lab.Matrix{"input": copyOf(loss.Backward())}
+	return c.check(f, map[string]*lab.Matrix{"input": x}, grads)
+}
+
+func copyOf(m *lab.Matrix) *lab.Matrix {
+	return lab.NewMatrix(m.Rows, m.Cols).CopyFrom(m)
+}
+
+func sortedNames(m map[string]*lab.Matrix) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Worst returns the element with the largest relative error.
+func Worst(elems []Element) Element {
+	var worst Element
+	for _, e := range elems {
+		if e.RelErr >= worst.RelErr {
+			worst = e
+		}
+	}
+	return worst
+}
+
+// CheckLayer fails t if any gradient of layer at x has a relative error
+// above tol and an absolute error above the default AbsTol, reporting every
+// such element.
+func CheckLayer(t testing.TB, name string, layer nn.Layer, x *lab.Matrix, tol float64) {
+	t.Helper()
+	c := &Checker{}
+	c.report(t, name, c.Layer(layer, x), tol)
+}
+
+// CheckLoss fails t if any gradient of loss at x has a relative error above
+// tol and an absolute error above the default AbsTol, reporting every such
+// element.
+func CheckLoss(t testing.TB, name string, loss nn.Loss, x *lab.Matrix, tol float64) {
+	t.Helper()
+	c := &Checker{}
+	c.report(t, name, c.Loss(loss, x), tol)
+}
+
+func (c *Checker) report(t testing.TB, name string, elems []Element, tol float64) {
+	t.Helper()
+	for _, e := range elems {
+		if e.RelErr > tol && e.AbsErr > c.absTol() {
+			t.Errorf("%s: %v", name, e)
+		}
+	}
+}
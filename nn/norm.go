@@ -0,0 +1,166 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+)
+
+// batchNorm normalizes channels of a features x batch matrix whose rows are
+// grouped by channel, each channel owning an equal, contiguous block of rows.
+// While training it uses the statistics of the batch and updates the running
+// averages; at inference it uses the running averages.
+type batchNorm struct {
+	Momentum    float64
+	Eps         float64
+	Gain        *lab.Matrix
+	Bias        *lab.Matrix
+	RunningMean *lab.Matrix
+	RunningVar  *lab.Matrix
+
+	eval         bool
+	dGain, dBias *lab.Matrix
+	// normalized input and per-channel inverse standard deviations of the
+	// last Forward
+	xhat   *lab.Matrix
+	invStd []float64
+}
+
+func newBatchNorm(channels int) batchNorm {
+	return batchNorm{
+		Momentum:    .1,
+		Eps:         1e-5,
+		Gain:        lab.Solid(channels, 1, 1),
+		Bias:        lab.NewMatrix(channels, 1),
+		RunningMean: lab.NewMatrix(channels, 1),
+		RunningVar:  lab.Solid(channels, 1, 1),
+	}
+}
+
+func (b *batchNorm) SetTraining(training bool) {
+	b.eval = !training
+}
+
+func (b *batchNorm) Params() []*Param {
+	if b.dGain == nil {
+		b.dGain = lab.NewMatrix(b.Gain.Rows, 1)
+		b.dBias = lab.NewMatrix(b.Bias.Rows, 1)
+	}
+	return []*Param{
+		{Name: "Gain", Value: b.Gain, Grad: b.dGain},
+		{Name: "Bias", Value: b.Bias, Grad: b.dBias},
+	}
+}
+
+func (b *batchNorm) Update(rate float64) {
+	sgdUpdate(b, rate)
+}
+
+func (b *batchNorm) forward(matrix *lab.Matrix) *lab.Matrix {
+	channels := b.Gain.Rows
+	block := len(matrix.X) / channels
+	b.xhat = lab.NewMatrix(matrix.Rows, matrix.Cols)
+	if len(b.invStd) != channels {
+		b.invStd = make([]float64, channels)
+	}
+	out := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	for c := 0; c < channels; c++ {
+		x := matrix.X[c*block : (c+1)*block]
+		mean, variance := b.RunningMean.X[c], b.RunningVar.X[c]
+		if !b.eval {
+			mean, variance = 0, 0
+			for _, v := range x {
+				mean += v
+			}
+			mean /= float64(block)
+			for _, v := range x {
+				variance += (v - mean) * (v - mean)
+			}
+			variance /= float64(block)
+			unbiased := variance
+			if block > 1 {
+				unbiased *= float64(block) / float64(block-1)
+			}
+			b.RunningMean.X[c] += b.Momentum * (mean - b.RunningMean.X[c])
+			b.RunningVar.X[c] += b.Momentum * (unbiased - b.RunningVar.X[c])
+		}
+		inv := 1 / math.Sqrt(variance+b.Eps)
+		b.invStd[c] = inv
+		for i, v := range x {
+			xh := (v - mean) * inv
+			b.xhat.X[c*block+i] = xh
+			out.X[c*block+i] = xh*b.Gain.X[c] + b.Bias.X[c]
+		}
+	}
+	return out
+}
+
+func (b *batchNorm) backward(matrix *lab.Matrix) *lab.Matrix {
+	b.Params()
+	channels := b.Gain.Rows
+	block := len(matrix.X) / channels
+	n := float64(block)
+	ret := lab.NewMatrix(matrix.Rows, matrix.Cols)
+	for c := 0; c < channels; c++ {
+		g := matrix.X[c*block : (c+1)*block]
+		xhat := b.xhat.X[c*block : (c+1)*block]
+		var sum, dot float64
+		for i, gi := range g {
+			b.dGain.X[c] += gi * xhat[i]
+			b.dBias.X[c] += gi
+			sum += gi
+			dot += gi * xhat[i]
+		}
+		scale := b.Gain.X[c] * b.invStd[c]
+		for i, gi := range g {
+			if b.eval {
+				// The running statistics are constants.
+				ret.X[c*block+i] = gi * scale
+			} else {
+				ret.X[c*block+i] = scale / n * (n*gi - sum - xhat[i]*dot)
+			}
+		}
+	}
+	return ret
+}
+
+// BatchNorm1D normalizes each of Features features over the batch.
+type BatchNorm1D struct {
+	Features int
+	batchNorm
+}
+
+func NewBatchNorm1D(features int) *BatchNorm1D {
+	return &BatchNorm1D{Features: features, batchNorm: newBatchNorm(features)}
+}
+
+func (b *BatchNorm1D) Forward(matrix *lab.Matrix) *lab.Matrix {
+	if matrix.Rows != b.Features {
+		panic(fmt.Sprintf("nn: BatchNorm1D expects %d features, got %d", b.Features, matrix.Rows))
+	}
+	return b.forward(matrix)
+}
+
+func (b *BatchNorm1D) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return b.backward(matrix)
+}
+
+// BatchNorm2D normalizes each channel of images of shape In over the batch
+// and every position of the image.
+type BatchNorm2D struct {
+	In Shape
+	batchNorm
+}
+
+func NewBatchNorm2D(in Shape) *BatchNorm2D {
+	return &BatchNorm2D{In: in, batchNorm: newBatchNorm(in.C)}
+}
+
+func (b *BatchNorm2D) Forward(matrix *lab.Matrix) *lab.Matrix {
+	checkShape("BatchNorm2D", b.In, matrix)
+	return b.forward(matrix)
+}
+
+func (b *BatchNorm2D) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return b.backward(matrix)
+}
@@ -0,0 +1,99 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchNormRunningStats(t *testing.T) {
+	// Features are drawn with mean 3 and variance 4.
+	bn := NewBatchNorm1D(2)
+	model := &Network{Layers: []Layer{bn}}
+	for i := 0; i < 200; i++ {
+		x := lab.Gaussian(2, 64).Scale(2)
+		for k := range x.X {
+			x.X[k] += 3
+		}
+		out := model.Forward(x)
+		var mean float64
+		for _, v := range out.X[:64] {
+			mean += v / 64
+		}
+		if math.Abs(mean) > 1e-9 {
+			t.Fatalf("batch %d: training output has mean %v", i, mean)
+		}
+	}
+	for c := 0; c < 2; c++ {
+		if m := bn.RunningMean.X[c]; math.Abs(m-3) > .3 {
+			t.Errorf("feature %d: running mean %v, want 3", c, m)
+		}
+		if v := bn.RunningVar.X[c]; math.Abs(v-4) > .8 {
+			t.Errorf("feature %d: running variance %v, want 4", c, v)
+		}
+	}
+
+	// At inference a single sample is normalized with the running statistics.
+	model.SetTraining(false)
+	x := lab.NewMatrix(2, 1)
+	x.X[0], x.X[1] = 3, 5
+	out := model.Forward(x)
+	if math.Abs(out.X[0]) > .2 || math.Abs(out.X[1]-1) > .2 {
+		t.Errorf("inference output %v, want about [0 1]", out.X)
+	}
+	mean := bn.RunningMean.X[0]
+	model.Forward(x)
+	if bn.RunningMean.X[0] != mean {
+		t.Error("inference changed the running mean")
+	}
+}
+
+func TestBatchNorm2DChannels(t *testing.T) {
+	in := Shape{C: 2, H: 3, W: 3}
+	bn := NewBatchNorm2D(in)
+	x := lab.Gaussian(in.Size(), 4)
+	for k := 0; k < 9*4; k++ {
+		x.X[k] = 10 + 5*x.X[k]
+	}
+	out := bn.Forward(x)
+	for c := 0; c < 2; c++ {
+		var mean, sq float64
+		for _, v := range out.X[c*36 : (c+1)*36] {
+			mean += v / 36
+			sq += v * v / 36
+		}
+		if math.Abs(mean) > 1e-9 || math.Abs(sq-1) > 1e-3 {
+			t.Errorf("channel %d: mean %v, variance %v", c, mean, sq-mean*mean)
+		}
+	}
+}
+
+func TestSaveLoadBatchNorm(t *testing.T) {
+	model := &Network{Layers: []Layer{
+		NewBatchNorm2D(Shape{C: 2, H: 2, W: 2}),
+		&Flatten{In: Shape{C: 2, H: 2, W: 2}},
+		NewFCLayer(8, 3),
+		NewBatchNorm1D(3),
+	}}
+	for i := 0; i < 5; i++ {
+		model.Forward(lab.Gaussian(8, 4))
+	}
+	model.SetTraining(false)
+	fname := filepath.Join(t.TempDir(), "bn.json")
+	if err := model.SaveModel(fname); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadModel(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.SetTraining(false)
+	x := lab.Gaussian(8, 1)
+	want, got := model.Forward(x), loaded.Forward(x)
+	for i := range want.X {
+		if want.X[i] != got.X[i] {
+			t.Fatalf("output %d: got %v, want %v", i, got.X[i], want.X[i])
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"strings"
+)
+
+// Optimizer updates parameters from the gradients accumulated by Backward.
+// Optimizers keep per-parameter state keyed by Param.Name, so a network
+// should be stepped with the same names every time. Step leaves the
+// gradients alone; call ZeroGrad once they have been used.
+type Optimizer interface {
+	Step(params []*Param, rate float64)
+	// State returns the optimizer's state, named "<param>.<slot>", for
+	// saving in a checkpoint.
+	State() map[string]*lab.Matrix
+	// SetState restores state returned by State.
+	SetState(map[string]*lab.Matrix) error
+}
+
+// ZeroGrad clears the accumulated gradients of params.
+func ZeroGrad(params []*Param) {
+	for _, p := range params {
+		p.Grad.Zero()
+	}
+}
+
+// sgdUpdate takes a plain gradient step on the parameters of p and clears
+// their gradients, the Update of every layer with parameters.
+func sgdUpdate(p Parameterized, rate float64) {
+	params := p.Params()
+	(&SGD{}).Step(params, rate)
+	ZeroGrad(params)
+}
+
+// NewOptimizer returns an optimizer with default hyperparameters by name:
+// sgd, momentum, nesterov, adam, adamw or rmsprop.
+func NewOptimizer(name string) (Optimizer, error) {
+	switch strings.ToLower(name) {
+	case "sgd":
+		return &SGD{}, nil
+	case "momentum":
+		return NewMomentum(.9), nil
+	case "nesterov":
+		return NewNesterov(.9), nil
+	case "adam":
+		return NewAdam(), nil
+	case "adamw":
+		return NewAdamW(.01), nil
+	case "rmsprop":
+		return NewRMSProp(), nil
+	}
+	return nil, fmt.Errorf("nn: unknown optimizer %q", name)
+}
+
+// slots holds one matrix of optimizer state per parameter, allocated on first
+// use.
+type slots map[string]*lab.Matrix
+
+func (s slots) get(p *Param) *lab.Matrix {
+	m, ok := s[p.Name]
+	if !ok || m.Rows != p.Value.Rows || m.Cols != p.Value.Cols {
+		m = lab.NewMatrix(p.Value.Rows, p.Value.Cols)
+		s[p.Name] = m
+	}
+	return m
+}
+
+func (s slots) save(state map[string]*lab.Matrix, slot string) {
+	for name, m := range s {
+		state[name+"."+slot] = m
+	}
+}
+
+// load fills s from the entries of state ending in "."+slot.
+func (s slots) load(state map[string]*lab.Matrix, slot string) {
+	suffix := "." + slot
+	for name, m := range state {
+		if strings.HasSuffix(name, suffix) {
+			c := lab.NewMatrix(m.Rows, m.Cols)
+			s[strings.TrimSuffix(name, suffix)] = c.CopyFrom(m)
+		}
+	}
+}
+
+// SGD is plain gradient descent.
+type SGD struct{}
+
+func (o *SGD) Step(params []*Param, rate float64) {
+	for _, p := range params {
+		p.Value.AddScaled(-rate, p.Grad)
+	}
+}
+
+func (o *SGD) State() map[string]*lab.Matrix {
+	return map[string]*lab.Matrix{}
+}
+
+func (o *SGD) SetState(map[string]*lab.Matrix) error {
+	return nil
+}
+
+// Momentum is gradient descent with a velocity that decays by Mu each step.
+type Momentum struct {
+	Mu       float64
+	velocity slots
+}
+
+func NewMomentum(mu float64) *Momentum {
+	return &Momentum{Mu: mu, velocity: slots{}}
+}
+
+func (o *Momentum) Step(params []*Param, rate float64) {
+	if o.velocity == nil {
+		o.velocity = slots{}
+	}
+	for _, p := range params {
+		v := o.velocity.get(p)
+		v.ScaleInPlace(o.Mu).AddScaled(rate, p.Grad)
+		p.Value.AddScaled(-1, v)
+	}
+}
+
+func (o *Momentum) State() map[string]*lab.Matrix {
+	state := map[string]*lab.Matrix{}
+	o.velocity.save(state, "velocity")
+	return state
+}
+
+func (o *Momentum) SetState(state map[string]*lab.Matrix) error {
+	o.velocity = slots{}
+	o.velocity.load(state, "velocity")
+	return nil
+}
+
+// Nesterov is momentum that steps from the look-ahead position
+// w - Mu * velocity, in the form of Sutskever et al.
+type Nesterov struct {
+	Mu       float64
+	velocity slots
+}
+
+func NewNesterov(mu float64) *Nesterov {
+	return &Nesterov{Mu: mu, velocity: slots{}}
+}
+
+func (o *Nesterov) Step(params []*Param, rate float64) {
+	if o.velocity == nil {
+		o.velocity = slots{}
+	}
+	for _, p := range params {
+		v := o.velocity.get(p)
+		v.ScaleInPlace(o.Mu).AddScaled(rate, p.Grad)
+		p.Value.AddScaled(-o.Mu, v).AddScaled(-rate, p.Grad)
+	}
+}
+
+func (o *Nesterov) State() map[string]*lab.Matrix {
+	state := map[string]*lab.Matrix{}
+	o.velocity.save(state, "velocity")
+	return state
+}
+
+func (o *Nesterov) SetState(state map[string]*lab.Matrix) error {
+	o.velocity = slots{}
+	o.velocity.load(state, "velocity")
+	return nil
+}
+
+// Adam keeps bias-corrected running averages of the gradient and its square.
+// A non-zero WeightDecay gives AdamW, which shrinks the weights directly
+// instead of adding the decay to the gradient.
+type Adam struct {
+	Beta1       float64
+	Beta2       float64
+	Eps         float64
+	WeightDecay float64
+
+	t    int
+	m, v slots
+}
+
+func NewAdam() *Adam {
+	return &Adam{Beta1: .9, Beta2: .999, Eps: 1e-8, m: slots{}, v: slots{}}
+}
+
+func NewAdamW(weightDecay float64) *Adam {
+	a := NewAdam()
+	a.WeightDecay = weightDecay
+	return a
+}
+
+func (o *Adam) Step(params []*Param, rate float64) {
+	if o.m == nil {
+		o.m = slots{}
+	}
+	if o.v == nil {
+		o.v = slots{}
+	}
+	o.t++
+	c1 := 1 - math.Pow(o.Beta1, float64(o.t))
+	c2 := 1 - math.Pow(o.Beta2, float64(o.t))
+	for _, p := range params {
+		m, v := o.m.get(p), o.v.get(p)
+		if o.WeightDecay != 0 {
+			p.Value.ScaleInPlace(1 - rate*o.WeightDecay)
+		}
+		for i, g := range p.Grad.X {
+			m.X[i] = o.Beta1*m.X[i] + (1-o.Beta1)*g
+			v.X[i] = o.Beta2*v.X[i] + (1-o.Beta2)*g*g
+			p.Value.X[i] -= rate * (m.X[i] / c1) / (math.Sqrt(v.X[i]/c2) + o.Eps)
+		}
+	}
+}
+
+func (o *Adam) State() map[string]*lab.Matrix {
+	state := map[string]*lab.Matrix{"t": lab.Solid(1, 1, float64(o.t))}
+	o.m.save(state, "m")
+	o.v.save(state, "v")
+	return state
+}
+
+func (o *Adam) SetState(state map[string]*lab.Matrix) error {
+	t, ok := state["t"]
+	if !ok || len(t.X) != 1 {
+		return fmt.Errorf("nn: adam state has no step count")
+	}
+	o.t = int(t.X[0])
+	o.m, o.v = slots{}, slots{}
+	o.m.load(state, "m")
+	o.v.load(state, "v")
+	return nil
+}
+
+// RMSProp divides the gradient by a running average of its magnitude.
+type RMSProp struct {
+	Decay float64
+	Eps   float64
+	sq    slots
+}
+
+func NewRMSProp() *RMSProp {
+	return &RMSProp{Decay: .9, Eps: 1e-8, sq: slots{}}
+}
+
+func (o *RMSProp) Step(params []*Param, rate float64) {
+	if o.sq == nil {
+		o.sq = slots{}
+	}
+	for _, p := range params {
+		sq := o.sq.get(p)
+		for i, g := range p.Grad.X {
+			sq.X[i] = o.Decay*sq.X[i] + (1-o.Decay)*g*g
+			p.Value.X[i] -= rate * g / (math.Sqrt(sq.X[i]) + o.Eps)
+		}
+	}
+}
+
+func (o *RMSProp) State() map[string]*lab.Matrix {
+	state := map[string]*lab.Matrix{}
+	o.sq.save(state, "sq")
+	return state
+}
+
+func (o *RMSProp) SetState(state map[string]*lab.Matrix) error {
+	o.sq = slots{}
+	o.sq.load(state, "sq")
+	return nil
+}
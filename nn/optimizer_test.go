@@ -0,0 +1,133 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"testing"
+)
+
+// TestOptimizersMinimize checks that every optimizer drives a quadratic bowl
+// f(w) = |w - target|^2 / 2 to its minimum.
+func TestOptimizersMinimize(t *testing.T) {
+	for _, name := range []string{"sgd", "momentum", "nesterov", "adam", "adamw", "rmsprop"} {
+		opt, err := NewOptimizer(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target := lab.NewVector([]float64{1, -2, 3}).Col()
+		p := &Param{Name: "w", Value: lab.NewMatrix(3, 1), Grad: lab.NewMatrix(3, 1)}
+		for i := 0; i < 2000; i++ {
+			lab.SubInto(p.Grad, p.Value, target)
+			opt.Step([]*Param{p}, .01)
+			ZeroGrad([]*Param{p})
+		}
+		tol := 1e-3
+		if name == "adamw" {
+			// weight decay pulls the minimum towards zero
+			tol = .1
+		}
+		for i, x := range p.Value.X {
+			if math.Abs(x-target.X[i]) > tol {
+				t.Errorf("%s: w[%d] = %v, want %v", name, i, x, target.X[i])
+			}
+		}
+	}
+}
+
+func TestOptimizerStateRoundTrip(t *testing.T) {
+	grad := lab.NewVector([]float64{.5, -1}).Col()
+	run := func(opt Optimizer, w *lab.Matrix) {
+		p := &Param{Name: "w", Value: w, Grad: lab.NewMatrix(2, 1).CopyFrom(grad)}
+		opt.Step([]*Param{p}, .1)
+	}
+	for _, name := range []string{"momentum", "nesterov", "adam", "rmsprop"} {
+		a, _ := NewOptimizer(name)
+		w := lab.NewMatrix(2, 1)
+		run(a, w)
+
+		b, _ := NewOptimizer(name)
+		if err := b.SetState(a.State()); err != nil {
+			t.Fatal(name, err)
+		}
+		w2 := lab.NewMatrix(2, 1).CopyFrom(w)
+		run(a, w)
+		run(b, w2)
+		for i := range w.X {
+			if w.X[i] != w2.X[i] {
+				t.Errorf("%s: restored optimizer stepped to %v, want %v", name, w2.X, w.X)
+				break
+			}
+		}
+	}
+}
+
+func TestClippers(t *testing.T) {
+	params := func() []*Param {
+		return []*Param{
+			{Name: "a", Value: lab.NewMatrix(2, 1), Grad: lab.NewVector([]float64{3, -4}).Col()},
+			{Name: "b", Value: lab.NewMatrix(1, 1), Grad: lab.NewVector([]float64{.5}).Col()},
+		}
+	}
+	cases := []struct {
+		c    Clipper
+		want []float64
+	}{
+		{&ClipValue{Max: 1}, []float64{1, -1, .5}},
+		{&ClipNorm{Max: 1}, []float64{.6, -.8, .5}},
+		{&ClipGlobalNorm{Max: 1}, []float64{3 / 5.0249, -4 / 5.0249, .5 / 5.0249}},
+		{&ClipGlobalNorm{Max: 10}, []float64{3, -4, .5}},
+	}
+	for _, c := range cases {
+		p := params()
+		fired := c.c.Clip(p)
+		got := append(append([]float64{}, p[0].Grad.X...), p[1].Grad.X...)
+		for i := range got {
+			if math.Abs(got[i]-c.want[i]) > 1e-4 {
+				t.Errorf("%T: got %v, want %v", c.c, got, c.want)
+				break
+			}
+		}
+		wantFired := got[0] != 3
+		if fired != wantFired || c.c.Stats().Steps != 1 || (c.c.Stats().Clipped == 1) != wantFired {
+			t.Errorf("%T: fired %v, stats %+v", c.c, fired, c.c.Stats())
+		}
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	cases := []struct {
+		s     Schedule
+		steps []int
+		want  []float64
+	}{
+		{&StepDecay{Base: 1, Factor: .5, Every: 10}, []int{0, 9, 10, 25}, []float64{1, 1, .5, .25}},
+		{&Exponential{Base: 2, Gamma: .5}, []int{0, 1, 3}, []float64{2, 1, .25}},
+		{&CosineRestarts{Max: 1, Period: 10}, []int{0, 5, 10, 15}, []float64{1, .5, 1, .5}},
+		{&CosineRestarts{Max: 1, Period: 10, Mult: 2}, []int{10, 20, 30}, []float64{1, .5, 1}},
+		// Zero periods mean 1 instead of dividing by zero or never restarting.
+		{&StepDecay{Base: 1, Factor: .5}, []int{0, 1, 2}, []float64{1, .5, .25}},
+		{&CosineRestarts{Max: 1}, []int{0, 7}, []float64{1, 1}},
+		{&CosineRestarts{Max: 1, Mult: 2}, []int{0, 3, 7}, []float64{1, 1, 1}},
+		{&Warmup{Steps: 4, Schedule: &Constant{R: 1}}, []int{0, 1, 3, 4}, []float64{.25, .5, 1, 1}},
+	}
+	for _, c := range cases {
+		for i, step := range c.steps {
+			if got := c.s.Rate(step); math.Abs(got-c.want[i]) > 1e-12 {
+				t.Errorf("%T.Rate(%d) = %v, want %v", c.s, step, got, c.want[i])
+			}
+		}
+	}
+
+	// With Patience 2 the rate halves on the second observation in a row
+	// without improvement, then the count starts over.
+	p := NewReduceOnPlateau(1)
+	p.Patience = 2
+	metrics := []float64{5, 4, 4, 4, 4, 4, 3, 3}
+	want := []float64{1, 1, 1, .5, .5, .25, .25, .25}
+	for i, metric := range metrics {
+		p.Observe(metric)
+		if got := p.Rate(0); got != want[i] {
+			t.Errorf("plateau rate after observation %d = %v, want %v", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"strconv"
+)
+
+// Param is a trainable matrix of a layer together with the gradient that
+// Backward accumulates for it.
+type Param struct {
+	Name  string
+	Value *lab.Matrix
+	Grad  *lab.Matrix
+}
+
+// Parameterized is implemented by layers that have trainable parameters.
+type Parameterized interface {
+	Params() []*Param
+}
+
+func (f *FCLayer) Params() []*Param {
+	f.ensureBuffers()
+	return []*Param{
+		{Name: "W", Value: f.W, Grad: f.Wprime},
+		{Name: "B", Value: f.B, Grad: f.Bprime},
+	}
+}
+
+// Params returns the parameters of every layer in the network. Names are
+// prefixed with the index of the layer, so the second weight matrix of the
+// first sub-network is named "0.1.W".
+func (n *Network) Params() []*Param {
+	var params []*Param
+	for i, layer := range n.Layers {
+		p, ok := layer.(Parameterized)
+		if !ok {
+			continue
+		}
+		prefix := strconv.Itoa(i) + "."
+		for _, param := range p.Params() {
+			params = append(params, &Param{
+				Name:  prefix + param.Name,
+				Value: param.Value,
+				Grad:  param.Grad,
+			})
+		}
+	}
+	return params
+}
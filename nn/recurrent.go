@@ -0,0 +1,416 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"math/rand"
+)
+
+// Sequence is implemented by layers that run over a sequence of steps, each
+// an in x batch matrix. As Layers they take the steps stacked vertically,
+// (steps*in) x batch, and return the stacked outputs.
+//
+// ForwardSeq returns the hidden state after every step and BackwardSeq takes
+// the gradient with respect to each of them and returns the gradients of the
+// inputs, backpropagating through time. If Truncate is positive, gradients
+// don't flow back across every Truncate-th step.
+//
+// A stateful layer starts each sequence from the hidden state that the last
+// one ended with, and ResetState returns it to zero; otherwise every sequence
+// starts from zero.
+type Sequence interface {
+	ForwardSeq(xs []*lab.Matrix) []*lab.Matrix
+	BackwardSeq(grads []*lab.Matrix) []*lab.Matrix
+	ResetState()
+}
+
+// splitSteps splits a (steps*rows) x batch matrix into its steps.
+func splitSteps(m *lab.Matrix, rows int) []*lab.Matrix {
+	if rows == 0 || m.Rows%rows != 0 {
+		panic(fmt.Sprintf("nn: %d rows is not a sequence of %d-row steps", m.Rows, rows))
+	}
+	steps := make([]*lab.Matrix, m.Rows/rows)
+	n := rows * m.Cols
+	for t := range steps {
+		steps[t] = &lab.Matrix{X: m.X[t*n : (t+1)*n], Rows: rows, Cols: m.Cols}
+	}
+	return steps
+}
+
+func stackSteps(steps []*lab.Matrix) *lab.Matrix {
+	return lab.VStack(steps...)
+}
+
+// rows returns a view of rows [i*h, (i+1)*h) of m.
+func rows(m *lab.Matrix, i, h int) *lab.Matrix {
+	n := h * m.Cols
+	return &lab.Matrix{X: m.X[i*n : (i+1)*n], Rows: h, Cols: m.Cols}
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+// recurrentParams holds the weights shared by the recurrent layers: Wx maps
+// the input and Wh the previous hidden state to gates*Hidden
+// pre-activations, plus the bias B.
+type recurrentParams struct {
+	Wx *lab.Matrix
+	Wh *lab.Matrix
+	B  *lab.Matrix
+
+	dWx, dWh, dB *lab.Matrix
+}
+
+// newRecurrentParams returns Xavier uniform input weights, orthogonal
+// recurrent weights and zero biases.
+func newRecurrentParams(in, hidden, gates int) recurrentParams {
+	p := recurrentParams{
+		Wx: lab.NewMatrix(gates*hidden, in),
+		Wh: lab.NewMatrix(gates*hidden, hidden),
+		B:  lab.NewMatrix(gates*hidden, 1),
+	}
+	rng := newRand()
+	XavierUniform{}.Init(p.Wx, rng)
+	Orthogonal{}.Init(p.Wh, rng)
+	return p
+}
+
+func (p *recurrentParams) Init(w, b Initializer, rng *rand.Rand) error {
+	return initAll(w, b, rng, []*lab.Matrix{p.Wx, p.Wh}, []*lab.Matrix{p.B})
+}
+
+func (p *recurrentParams) params() []*Param {
+	if p.dWx == nil {
+		p.dWx = lab.NewMatrix(p.Wx.Rows, p.Wx.Cols)
+		p.dWh = lab.NewMatrix(p.Wh.Rows, p.Wh.Cols)
+		p.dB = lab.NewMatrix(p.B.Rows, p.B.Cols)
+	}
+	return []*Param{
+		{Name: "Wx", Value: p.Wx, Grad: p.dWx},
+		{Name: "Wh", Value: p.Wh, Grad: p.dWh},
+		{Name: "B", Value: p.B, Grad: p.dB},
+	}
+}
+
+// affine returns Wx*x + Wh*h + B.
+func (p *recurrentParams) affine(x, h *lab.Matrix) *lab.Matrix {
+	z := p.Wx.Multiply(x)
+	lab.AddInto(z, z, p.Wh.Multiply(h))
+	return lab.AddColInto(z, z, p.B)
+}
+
+// backward accumulates the weight gradients of affine given dx, the gradient
+// of its pre-activations through Wx and B, and dh, through Wh. They differ
+// only for the GRU. It returns the gradients of x and h.
+func (p *recurrentParams) backward(x, h, dx, dh *lab.Matrix) (*lab.Matrix, *lab.Matrix) {
+	p.params()
+	p.dWx.AddScaled(1, dx.Multiply(x.Transpose()))
+	p.dWh.AddScaled(1, dh.Multiply(h.Transpose()))
+	p.dB.AddScaled(1, dx.SumCols())
+	return p.Wx.Transpose().Multiply(dx), p.Wh.Transpose().Multiply(dh)
+}
+
+// initial returns the state to start a sequence from: the carried state if
+// stateful and of the right size, zeros otherwise.
+func initial(state *lab.Matrix, stateful bool, hidden, batch int) *lab.Matrix {
+	if stateful && state != nil && state.Cols == batch {
+		return state
+	}
+	return lab.NewMatrix(hidden, batch)
+}
+
+// truncated reports whether the gradient flowing from step t to t-1 is cut.
+func truncated(t, every int) bool {
+	return every > 0 && t%every == 0
+}
+
+// RNN is an Elman recurrent layer, h_t = tanh(Wx x_t + Wh h_{t-1} + B).
+type RNN struct {
+	In       int
+	Hidden   int
+	Truncate int
+	Stateful bool
+	recurrentParams
+
+	// H is the hidden state after the last step of the last sequence.
+	H *lab.Matrix `json:"-"`
+	// per-step caches: the inputs, and the hidden states with the initial one
+	// first
+	xs, hs []*lab.Matrix
+}
+
+func NewRNN(in, hidden int) *RNN {
+	return &RNN{In: in, Hidden: hidden, recurrentParams: newRecurrentParams(in, hidden, 1)}
+}
+
+func (r *RNN) Params() []*Param {
+	return r.params()
+}
+
+func (r *RNN) ResetState() {
+	r.H = nil
+}
+
+func (r *RNN) ForwardSeq(xs []*lab.Matrix) []*lab.Matrix {
+	r.xs = xs
+	r.hs = []*lab.Matrix{initial(r.H, r.Stateful, r.Hidden, xs[0].Cols)}
+	for _, x := range xs {
+		z := r.affine(x, r.hs[len(r.hs)-1])
+		for i, v := range z.X {
+			z.X[i] = math.Tanh(v)
+		}
+		r.hs = append(r.hs, z)
+	}
+	r.H = r.hs[len(r.hs)-1]
+	return r.hs[1:]
+}
+
+func (r *RNN) BackwardSeq(grads []*lab.Matrix) []*lab.Matrix {
+	dxs := make([]*lab.Matrix, len(grads))
+	dh := lab.NewMatrix(r.Hidden, grads[0].Cols)
+	for t := len(grads) - 1; t >= 0; t-- {
+		h := r.hs[t+1]
+		da := lab.NewMatrix(h.Rows, h.Cols)
+		for i, v := range h.X {
+			da.X[i] = (grads[t].X[i] + dh.X[i]) * (1 - v*v)
+		}
+		dxs[t], dh = r.backward(r.xs[t], r.hs[t], da, da)
+		if truncated(t, r.Truncate) {
+			dh.Zero()
+		}
+	}
+	return dxs
+}
+
+func (r *RNN) Forward(matrix *lab.Matrix) *lab.Matrix {
+	return stackSteps(r.ForwardSeq(splitSteps(matrix, r.In)))
+}
+
+func (r *RNN) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return stackSteps(r.BackwardSeq(splitSteps(matrix, r.Hidden)))
+}
+
+func (r *RNN) Update(rate float64) {
+	sgdUpdate(r, rate)
+}
+
+// LSTM is a long short-term memory layer. Its gates are stacked in the order
+// input, forget, cell, output in the rows of the weights.
+type LSTM struct {
+	In       int
+	Hidden   int
+	Truncate int
+	Stateful bool
+	recurrentParams
+
+	// H and C are the hidden and cell state after the last step of the last
+	// sequence.
+	H *lab.Matrix `json:"-"`
+	C *lab.Matrix `json:"-"`
+	// per-step caches: inputs, gate activations, and hidden and cell states
+	// with the initial ones first
+	xs, gates, hs, cs []*lab.Matrix
+}
+
+// NewLSTM returns an LSTM with the forget gate bias set to 1, so it starts
+// out remembering.
+func NewLSTM(in, hidden int) *LSTM {
+	l := &LSTM{In: in, Hidden: hidden, recurrentParams: newRecurrentParams(in, hidden, 4)}
+	l.rememberBias()
+	return l
+}
+
+func (l *LSTM) rememberBias() {
+	for i := l.Hidden; i < 2*l.Hidden; i++ {
+		l.B.X[i] = 1
+	}
+}
+
+// Init initializes the weights and biases like the other recurrent layers,
+// then sets the forget gate bias back to 1.
+func (l *LSTM) Init(w, b Initializer, rng *rand.Rand) error {
+	if err := l.recurrentParams.Init(w, b, rng); err != nil {
+		return err
+	}
+	if b != nil {
+		l.rememberBias()
+	}
+	return nil
+}
+
+func (l *LSTM) Params() []*Param {
+	return l.params()
+}
+
+func (l *LSTM) ResetState() {
+	l.H, l.C = nil, nil
+}
+
+func (l *LSTM) ForwardSeq(xs []*lab.Matrix) []*lab.Matrix {
+	batch := xs[0].Cols
+	hd := l.Hidden
+	l.xs, l.gates = xs, nil
+	l.hs = []*lab.Matrix{initial(l.H, l.Stateful, hd, batch)}
+	l.cs = []*lab.Matrix{initial(l.C, l.Stateful, hd, batch)}
+	n := hd * batch
+	for _, x := range xs {
+		g := l.affine(x, l.hs[len(l.hs)-1])
+		cPrev := l.cs[len(l.cs)-1]
+		c, h := lab.NewMatrix(hd, batch), lab.NewMatrix(hd, batch)
+		for k := 0; k < n; k++ {
+			i, f := sigmoid(g.X[k]), sigmoid(g.X[n+k])
+			cand, o := math.Tanh(g.X[2*n+k]), sigmoid(g.X[3*n+k])
+			g.X[k], g.X[n+k], g.X[2*n+k], g.X[3*n+k] = i, f, cand, o
+			c.X[k] = f*cPrev.X[k] + i*cand
+			h.X[k] = o * math.Tanh(c.X[k])
+		}
+		l.gates = append(l.gates, g)
+		l.cs = append(l.cs, c)
+		l.hs = append(l.hs, h)
+	}
+	l.H, l.C = l.hs[len(l.hs)-1], l.cs[len(l.cs)-1]
+	return l.hs[1:]
+}
+
+func (l *LSTM) BackwardSeq(grads []*lab.Matrix) []*lab.Matrix {
+	batch := grads[0].Cols
+	n := l.Hidden * batch
+	dxs := make([]*lab.Matrix, len(grads))
+	dh := lab.NewMatrix(l.Hidden, batch)
+	dc := lab.NewMatrix(l.Hidden, batch)
+	for t := len(grads) - 1; t >= 0; t-- {
+		g, c, cPrev := l.gates[t], l.cs[t+1], l.cs[t]
+		dg := lab.NewMatrix(g.Rows, g.Cols)
+		for k := 0; k < n; k++ {
+			i, f, cand, o := g.X[k], g.X[n+k], g.X[2*n+k], g.X[3*n+k]
+			tc := math.Tanh(c.X[k])
+			dhk := grads[t].X[k] + dh.X[k]
+			dck := dc.X[k] + dhk*o*(1-tc*tc)
+			dg.X[k] = dck * cand * i * (1 - i)
+			dg.X[n+k] = dck * cPrev.X[k] * f * (1 - f)
+			dg.X[2*n+k] = dck * i * (1 - cand*cand)
+			dg.X[3*n+k] = dhk * tc * o * (1 - o)
+			dc.X[k] = dck * f
+		}
+		dxs[t], dh = l.backward(l.xs[t], l.hs[t], dg, dg)
+		if truncated(t, l.Truncate) {
+			dh.Zero()
+			dc.Zero()
+		}
+	}
+	return dxs
+}
+
+func (l *LSTM) Forward(matrix *lab.Matrix) *lab.Matrix {
+	return stackSteps(l.ForwardSeq(splitSteps(matrix, l.In)))
+}
+
+func (l *LSTM) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return stackSteps(l.BackwardSeq(splitSteps(matrix, l.Hidden)))
+}
+
+func (l *LSTM) Update(rate float64) {
+	sgdUpdate(l, rate)
+}
+
+// GRU is a gated recurrent unit layer. Its gates are stacked in the order
+// update, reset, candidate in the rows of the weights, and the reset gate
+// applies to Wh h_{t-1} in the candidate:
+//
+//	n_t = tanh(Wx_n x_t + B_n + r_t * (Wh_n h_{t-1}))
+//	h_t = (1 - z_t) * n_t + z_t * h_{t-1}
+type GRU struct {
+	In       int
+	Hidden   int
+	Truncate int
+	Stateful bool
+	recurrentParams
+
+	// H is the hidden state after the last step of the last sequence.
+	H *lab.Matrix `json:"-"`
+	// per-step caches: inputs, gate activations, Wh_n h_{t-1}, and hidden
+	// states with the initial one first
+	xs, gates, hn, hs []*lab.Matrix
+}
+
+func NewGRU(in, hidden int) *GRU {
+	return &GRU{In: in, Hidden: hidden, recurrentParams: newRecurrentParams(in, hidden, 3)}
+}
+
+func (r *GRU) Params() []*Param {
+	return r.params()
+}
+
+func (r *GRU) ResetState() {
+	r.H = nil
+}
+
+func (r *GRU) ForwardSeq(xs []*lab.Matrix) []*lab.Matrix {
+	batch := xs[0].Cols
+	hd := r.Hidden
+	n := hd * batch
+	r.xs, r.gates, r.hn = xs, nil, nil
+	r.hs = []*lab.Matrix{initial(r.H, r.Stateful, hd, batch)}
+	for _, x := range xs {
+		hPrev := r.hs[len(r.hs)-1]
+		g := lab.AddColInto(lab.NewMatrix(3*hd, batch), r.Wx.Multiply(x), r.B)
+		wh := r.Wh.Multiply(hPrev)
+		hn := lab.NewMatrix(hd, batch).CopyFrom(rows(wh, 2, hd))
+		h := lab.NewMatrix(hd, batch)
+		for k := 0; k < n; k++ {
+			z := sigmoid(g.X[k] + wh.X[k])
+			rg := sigmoid(g.X[n+k] + wh.X[n+k])
+			cand := math.Tanh(g.X[2*n+k] + rg*hn.X[k])
+			g.X[k], g.X[n+k], g.X[2*n+k] = z, rg, cand
+			h.X[k] = (1-z)*cand + z*hPrev.X[k]
+		}
+		r.gates = append(r.gates, g)
+		r.hn = append(r.hn, hn)
+		r.hs = append(r.hs, h)
+	}
+	r.H = r.hs[len(r.hs)-1]
+	return r.hs[1:]
+}
+
+func (r *GRU) BackwardSeq(grads []*lab.Matrix) []*lab.Matrix {
+	batch := grads[0].Cols
+	n := r.Hidden * batch
+	dxs := make([]*lab.Matrix, len(grads))
+	dh := lab.NewMatrix(r.Hidden, batch)
+	for t := len(grads) - 1; t >= 0; t-- {
+		g, hn, hPrev := r.gates[t], r.hn[t], r.hs[t]
+		dx := lab.NewMatrix(g.Rows, g.Cols)
+		dwh := lab.NewMatrix(g.Rows, g.Cols)
+		carry := lab.NewMatrix(r.Hidden, batch)
+		for k := 0; k < n; k++ {
+			z, rg, cand := g.X[k], g.X[n+k], g.X[2*n+k]
+			dhk := grads[t].X[k] + dh.X[k]
+			dn := dhk * (1 - z) * (1 - cand*cand)
+			dz := dhk * (hPrev.X[k] - cand) * z * (1 - z)
+			dr := dn * hn.X[k] * rg * (1 - rg)
+			dx.X[k], dx.X[n+k], dx.X[2*n+k] = dz, dr, dn
+			dwh.X[k], dwh.X[n+k], dwh.X[2*n+k] = dz, dr, dn*rg
+			carry.X[k] = dhk * z
+		}
+		dxs[t], dh = r.backward(r.xs[t], hPrev, dx, dwh)
+		lab.AddInto(dh, dh, carry)
+		if truncated(t, r.Truncate) {
+			dh.Zero()
+		}
+	}
+	return dxs
+}
+
+func (r *GRU) Forward(matrix *lab.Matrix) *lab.Matrix {
+	return stackSteps(r.ForwardSeq(splitSteps(matrix, r.In)))
+}
+
+func (r *GRU) Backward(matrix *lab.Matrix) *lab.Matrix {
+	return stackSteps(r.BackwardSeq(splitSteps(matrix, r.Hidden)))
+}
+
+func (r *GRU) Update(rate float64) {
+	sgdUpdate(r, rate)
+}
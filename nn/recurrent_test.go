@@ -0,0 +1,136 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"math"
+	"testing"
+)
+
+func recurrentLayers() map[string]interface {
+	Layer
+	Sequence
+} {
+	return map[string]interface {
+		Layer
+		Sequence
+	}{
+		"RNN":  NewRNN(2, 3),
+		"LSTM": NewLSTM(2, 3),
+		"GRU":  NewGRU(2, 3),
+	}
+}
+
+func TestTruncatedBPTT(t *testing.T) {
+	for name, l := range recurrentLayers() {
+		xs := []*lab.Matrix{lab.Gaussian(2, 2), lab.Gaussian(2, 2), lab.Gaussian(2, 2), lab.Gaussian(2, 2)}
+		grads := []*lab.Matrix{lab.NewMatrix(3, 2), lab.NewMatrix(3, 2), lab.NewMatrix(3, 2), lab.Solid(3, 2, 1)}
+		switch r := l.(type) {
+		case *RNN:
+			r.Truncate = 2
+		case *LSTM:
+			r.Truncate = 2
+		case *GRU:
+			r.Truncate = 2
+		}
+		l.ForwardSeq(xs)
+		dxs := l.BackwardSeq(grads)
+		// The loss at step 3 reaches steps 2 and 3 but not across the cut
+		// before step 2.
+		for step, dx := range dxs {
+			var norm float64
+			for _, g := range dx.X {
+				norm += g * g
+			}
+			if (step >= 2) != (norm > 0) {
+				t.Errorf("%s: gradient norm %v at step %d", name, norm, step)
+			}
+		}
+	}
+}
+
+func TestStatefulRecurrent(t *testing.T) {
+	x := lab.Gaussian(2*6, 3)
+	for name, l := range recurrentLayers() {
+		whole := l.Forward(x)
+		switch r := l.(type) {
+		case *RNN:
+			r.Stateful = true
+		case *LSTM:
+			r.Stateful = true
+		case *GRU:
+			r.Stateful = true
+		}
+		// Feeding the sequence in two halves carries the hidden state over.
+		l.ResetState()
+		first := l.Forward(&lab.Matrix{X: x.X[:2*3*3], Rows: 6, Cols: 3})
+		second := l.Forward(&lab.Matrix{X: x.X[2*3*3:], Rows: 6, Cols: 3})
+		halves := lab.VStack(first, second)
+		for i := range whole.X {
+			if math.Abs(whole.X[i]-halves.X[i]) > 1e-12 {
+				t.Errorf("%s: stateful output %d is %v, want %v", name, i, halves.X[i], whole.X[i])
+				break
+			}
+		}
+		l.ResetState()
+		if again := l.Forward(x); again.X[0] != whole.X[0] {
+			t.Errorf("%s: ResetState didn't clear the hidden state", name)
+		}
+	}
+}
+
+func TestLSTMLearnsToRemember(t *testing.T) {
+	// The target at every step is the input of the first step.
+	lstm := NewLSTM(1, 4)
+	model := &Network{Layers: []Layer{lstm}}
+	opt := NewAdam()
+	var last float64
+	for i := 0; i < 300; i++ {
+		x := lab.NewMatrix(5, 8)
+		target := lab.NewMatrix(4*5, 8)
+		for j := 0; j < 8; j++ {
+			v := float64(j%2)*2 - 1
+			x.X[j] = v
+			for s := 0; s < 5; s++ {
+				target.X[(s*4)*8+j] = v * .5
+			}
+		}
+		out := model.Forward(x)
+		grad := lab.NewMatrix(out.Rows, out.Cols)
+		last = 0
+		for s := 0; s < 5; s++ {
+			for j := 0; j < 8; j++ {
+				k := (s*4)*8 + j
+				d := out.X[k] - target.X[k]
+				grad.X[k] = 2 * d
+				last += d * d
+			}
+		}
+		model.Backward(grad)
+		opt.Step(model.Params(), .02)
+		ZeroGrad(model.Params())
+	}
+	if last > .05 {
+		t.Errorf("loss %v after training", last)
+	}
+}
+
+func TestSaveLoadRecurrent(t *testing.T) {
+	model := &Network{Layers: []Layer{NewLSTM(2, 3), NewGRU(3, 2), NewRNN(2, 2)}}
+	fname := t.TempDir() + "/rnn.json"
+	if err := model.SaveModel(fname); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadModel(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := lab.Gaussian(2*4, 2)
+	want, got := model.Forward(x), loaded.Forward(x)
+	for i := range want.X {
+		if want.X[i] != got.X[i] {
+			t.Fatalf("output %d: got %v, want %v", i, got.X[i], want.X[i])
+		}
+	}
+	loaded.Backward(got)
+	loaded.Update(.1)
+}
@@ -0,0 +1,208 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+)
+
+// Schedule gives the learning rate to use at each training step. Schedules
+// with state report it through State so that it can go into a checkpoint.
+type Schedule interface {
+	Rate(step int) float64
+	State() map[string]float64
+	SetState(map[string]float64) error
+}
+
+// Observer is implemented by schedules that adapt to an evaluation metric,
+// such as ReduceOnPlateau. Lower metrics are better.
+type Observer interface {
+	Observe(metric float64)
+}
+
+// stateless can be embedded by schedules that only depend on the step.
+type stateless struct{}
+
+func (stateless) State() map[string]float64 {
+	return map[string]float64{}
+}
+
+func (stateless) SetState(map[string]float64) error {
+	return nil
+}
+
+// Constant always returns R.
+type Constant struct {
+	stateless
+	R float64
+}
+
+func (s *Constant) Rate(int) float64 {
+	return s.R
+}
+
+// StepDecay multiplies Base by Factor every Every steps. An Every below 1
+// means 1.
+type StepDecay struct {
+	stateless
+	Base   float64
+	Factor float64
+	Every  int
+}
+
+func (s *StepDecay) Rate(step int) float64 {
+	return s.Base * math.Pow(s.Factor, float64(step/atLeastOne(s.Every)))
+}
+
+// Exponential decays Base by Gamma every step.
+type Exponential struct {
+	stateless
+	Base  float64
+	Gamma float64
+}
+
+func (s *Exponential) Rate(step int) float64 {
+	return s.Base * math.Pow(s.Gamma, float64(step))
+}
+
+// CosineRestarts anneals from Max to Min along half a cosine over Period
+// steps, then restarts. Each period is Mult times longer than the last; a
+// Mult of 0 or 1 keeps the period fixed. A Period below 1 means 1.
+type CosineRestarts struct {
+	stateless
+	Max    float64
+	Min    float64
+	Period int
+	Mult   float64
+}
+
+func (s *CosineRestarts) Rate(step int) float64 {
+	period := float64(atLeastOne(s.Period))
+	t := float64(step)
+	if s.Mult > 1 {
+		for t >= period {
+			t -= period
+			period *= s.Mult
+		}
+	} else {
+		t = math.Mod(t, period)
+	}
+	return s.Min + (s.Max-s.Min)*(1+math.Cos(math.Pi*t/period))/2
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Warmup ramps the rate up linearly over the first Steps steps to the first
+// rate of Schedule, which then takes over starting from its own step 0.
+type Warmup struct {
+	Steps    int
+	Schedule Schedule
+}
+
+func (s *Warmup) Rate(step int) float64 {
+	if step < s.Steps {
+		return s.Schedule.Rate(0) * float64(step+1) / float64(s.Steps)
+	}
+	return s.Schedule.Rate(step - s.Steps)
+}
+
+func (s *Warmup) State() map[string]float64 {
+	return s.Schedule.State()
+}
+
+func (s *Warmup) SetState(state map[string]float64) error {
+	return s.Schedule.SetState(state)
+}
+
+func (s *Warmup) Observe(metric float64) {
+	if o, ok := s.Schedule.(Observer); ok {
+		o.Observe(metric)
+	}
+}
+
+// ReduceOnPlateau starts at Base and multiplies the rate by Factor whenever
+// the observed metric has not improved on its best value by more than
+// Threshold for Patience observations in a row. The rate never drops below
+// Min.
+type ReduceOnPlateau struct {
+	Base      float64
+	Factor    float64
+	Patience  int
+	Threshold float64
+	Min       float64
+
+	scale float64
+	best  float64
+	bad   int
+	seen  bool
+}
+
+func NewReduceOnPlateau(base float64) *ReduceOnPlateau {
+	return &ReduceOnPlateau{Base: base, Factor: .5, Patience: 3, scale: 1}
+}
+
+func (s *ReduceOnPlateau) Rate(int) float64 {
+	if s.scale == 0 {
+		s.scale = 1
+	}
+	return math.Max(s.Base*s.scale, s.Min)
+}
+
+func (s *ReduceOnPlateau) Observe(metric float64) {
+	if s.scale == 0 {
+		s.scale = 1
+	}
+	if !s.seen || metric < s.best-s.Threshold {
+		s.best = metric
+		s.seen = true
+		s.bad = 0
+		return
+	}
+	s.bad++
+	if s.bad >= s.Patience {
+		s.scale *= s.Factor
+		s.bad = 0
+	}
+}
+
+func (s *ReduceOnPlateau) State() map[string]float64 {
+	state := map[string]float64{"scale": s.scale, "bad": float64(s.bad)}
+	if s.seen {
+		state["best"] = s.best
+	}
+	return state
+}
+
+func (s *ReduceOnPlateau) SetState(state map[string]float64) error {
+	scale, ok := state["scale"]
+	if !ok {
+		return fmt.Errorf("nn: plateau schedule state has no scale")
+	}
+	s.scale = scale
+	s.bad = int(state["bad"])
+	s.best, s.seen = state["best"]
+	return nil
+}
+
+// NewSchedule returns a schedule starting at rate base by name, with
+// defaults suited to a few thousand steps per epoch: constant, step (halve
+// every 10000 steps), exp, cosine (restart every 10000 steps) or plateau.
+func NewSchedule(name string, base float64) (Schedule, error) {
+	switch name {
+	case "constant":
+		return &Constant{R: base}, nil
+	case "step":
+		return &StepDecay{Base: base, Factor: .5, Every: 10000}, nil
+	case "exp":
+		return &Exponential{Base: base, Gamma: .99999}, nil
+	case "cosine":
+		return &CosineRestarts{Max: base, Period: 10000}, nil
+	case "plateau":
+		return NewReduceOnPlateau(base), nil
+	}
+	return nil, fmt.Errorf("nn: unknown schedule %q", name)
+}
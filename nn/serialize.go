@@ -0,0 +1,161 @@
+package nn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// layerTypes maps a registered name to the concrete (non-pointer) layer type
+// and layerNames is the reverse mapping used when saving.
+var (
+	layerTypes = map[string]reflect.Type{}
+	layerNames = map[reflect.Type]string{}
+)
+
+// RegisterLayer makes a layer type available to LoadModel under name. l must
+// be a pointer to the layer type, e.g. RegisterLayer("FCLayer", &FCLayer{}).
+// Exported fields of the layer are saved; fields tagged `json:"-"` are treated
+// as scratch state and must be rebuilt by the layer on demand.
+func RegisterLayer(name string, l Layer) {
+	t := reflect.TypeOf(l)
+	if t.Kind() != reflect.Ptr {
+		panic("nn: RegisterLayer needs a pointer to a layer, got " + t.String())
+	}
+	if _, ok := layerTypes[name]; ok {
+		panic("nn: layer " + name + " registered twice")
+	}
+	layerTypes[name] = t.Elem()
+	layerNames[t] = name
+}
+
+func init() {
+	RegisterLayer("Network", &Network{})
+	RegisterLayer("FCLayer", &FCLayer{})
+	RegisterLayer("Reparam", &Reparam{})
+	RegisterLayer("TanhActivation", &TanhActivation{})
+	RegisterLayer("Sigmoid", &Sigmoid{})
+	RegisterLayer("ReLU", &RELU{})
+	RegisterLayer("LeakyReLU", &LeakyReLU{})
+	RegisterLayer("PReLU", &PReLU{})
+	RegisterLayer("ELU", &ELU{})
+	RegisterLayer("SELU", &SELU{})
+	RegisterLayer("GELU", &GELU{})
+	RegisterLayer("Swish", &Swish{})
+	RegisterLayer("Softplus", &Softplus{})
+	RegisterLayer("Mish", &Mish{})
+	RegisterLayer("HardTanh", &HardTanh{})
+	RegisterLayer("Softmax", &Softmax{})
+	RegisterLayer("LogSoftmax", &LogSoftmax{})
+	RegisterLayer("Scale", &Scale{})
+	RegisterLayer("Translate", &Translate{})
+	RegisterLayer("Conv2D", &Conv2D{})
+	RegisterLayer("MaxPool2D", &MaxPool2D{})
+	RegisterLayer("AvgPool2D", &AvgPool2D{})
+	RegisterLayer("Reshape", &Reshape{})
+	RegisterLayer("Flatten", &Flatten{})
+	RegisterLayer("RNN", &RNN{})
+	RegisterLayer("LSTM", &LSTM{})
+	RegisterLayer("GRU", &GRU{})
+	RegisterLayer("TimeDistributed", &TimeDistributed{})
+	RegisterLayer("LayerNorm", &LayerNorm{})
+	RegisterLayer("PositionalEncoding", &PositionalEncoding{})
+	RegisterLayer("MultiHeadAttention", &MultiHeadAttention{})
+	RegisterLayer("TransformerBlock", &TransformerBlock{})
+	RegisterLayer("BatchNorm1D", &BatchNorm1D{})
+	RegisterLayer("BatchNorm2D", &BatchNorm2D{})
+	RegisterLayer("Dropout", &Dropout{})
+}
+
+// legacyLayers decodes layer types whose behaviour changed under a new name,
+// keeping the behaviour the file was saved with. "RELU" was a leaky ReLU with
+// slope .1; the true ReLU is saved as "ReLU".
+var legacyLayers = map[string]func() Layer{
+	"RELU": func() Layer { return &LeakyReLU{Slope: .1} },
+}
+
+// layerRecord is the on-disk form of a single layer: its registered type name
+// and the layer's own JSON encoding.
+type layerRecord struct {
+	Type  string
+	Layer json.RawMessage
+}
+
+type networkRecord struct {
+	Layers []layerRecord
+}
+
+func (n *Network) MarshalJSON() ([]byte, error) {
+	records := make([]layerRecord, len(n.Layers))
+	for i, layer := range n.Layers {
+		name, ok := layerNames[reflect.TypeOf(layer)]
+		if !ok {
+			return nil, fmt.Errorf("nn: layer %d has unregistered type %T", i, layer)
+		}
+		raw, err := json.Marshal(layer)
+		if err != nil {
+			return nil, fmt.Errorf("nn: encoding layer %d (%s): %v", i, name, err)
+		}
+		records[i] = layerRecord{Type: name, Layer: raw}
+	}
+	return json.Marshal(networkRecord{Layers: records})
+}
+
+func (n *Network) UnmarshalJSON(b []byte) error {
+	var rec networkRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return err
+	}
+	layers := make([]Layer, len(rec.Layers))
+	for i, r := range rec.Layers {
+		if legacy, ok := legacyLayers[r.Type]; ok {
+			layers[i] = legacy()
+			continue
+		}
+		t, ok := layerTypes[r.Type]
+		if !ok {
+			return fmt.Errorf("nn: layer %d has unknown type %q", i, r.Type)
+		}
+		v := reflect.New(t)
+		if err := json.Unmarshal(r.Layer, v.Interface()); err != nil {
+			return fmt.Errorf("nn: decoding layer %d (%s): %v", i, r.Type, err)
+		}
+		layers[i] = v.Interface().(Layer)
+	}
+	n.Layers = layers
+	return nil
+}
+
+// SaveModel writes the network, including the type of every layer, to
+// fileName so that it can be rebuilt with LoadModel.
+func (n *Network) SaveModel(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(n); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadModel replaces the layers of n with the model saved in fileName.
+func (n *Network) LoadModel(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(n)
+}
+
+// LoadModel rebuilds a network saved with SaveModel.
+func LoadModel(fileName string) (*Network, error) {
+	n := &Network{}
+	if err := n.LoadModel(fileName); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
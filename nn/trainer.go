@@ -0,0 +1,292 @@
+package nn
+
+import (
+	"fmt"
+	"github.com/wizgrao/ml/lab"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Batches supplies training data for the Trainer. Next returns the inputs of
+// a batch as a features x batch matrix together with its targets, and a nil x
+// once the epoch is over. Reset starts a new epoch.
+type Batches interface {
+	Next() (x, y *lab.Matrix)
+	Reset()
+}
+
+// EpochSetter is implemented by Batches whose order depends on the epoch
+// number, such as data.Loader. Fit calls SetEpoch with the number of the
+// epoch about to start before Reset, so a resumed run sees the data in the
+// same order as an uninterrupted one.
+type EpochSetter interface {
+	SetEpoch(epoch int)
+}
+
+// Targeted is implemented by losses that compare the output with a per-batch
+// target. The Trainer calls SetTarget with the targets of every batch.
+type Targeted interface {
+	SetTarget(y *lab.Matrix)
+}
+
+// Callback hooks into the Trainer. OnStep runs after every optimizer step with
+// the loss of that batch, OnEpoch after every epoch. An error from OnEpoch
+// stops training and is returned by Fit.
+type Callback interface {
+	OnStep(t *Trainer, loss float64)
+	OnEpoch(t *Trainer) error
+}
+
+// EpochFunc adapts a function to a Callback that only runs at epoch ends.
+type EpochFunc func(t *Trainer) error
+
+func (f EpochFunc) OnStep(*Trainer, float64) {}
+
+func (f EpochFunc) OnEpoch(t *Trainer) error {
+	return f(t)
+}
+
+// Trainer runs the training loop: for every batch from Data it runs Model
+// forward and backward through Loss, clips the gradients with Clipper if set
+// and steps Optimizer with the rate from Schedule (or the fixed Rate if
+// Schedule is nil).
+type Trainer struct {
+	Model     *Network
+	Loss      Loss
+	Data      Batches
+	Optimizer Optimizer
+	Schedule  Schedule
+	Rate      float64
+	Clipper   Clipper
+	Callbacks []Callback
+
+	// StepFunc replaces the default forward and backward pass for models that
+	// don't fit a single loss, such as a VAE. It must leave the gradients
+	// accumulated in the model's parameters and return the batch loss.
+	StepFunc func(x, y *lab.Matrix) float64
+
+	// Monitor names the metric given to schedules that implement Observer at
+	// the end of every epoch. It defaults to "loss".
+	Monitor string
+
+	// Seed reseeds the stochastic layers of Model at the start of every
+	// epoch with a seed derived from Seed and the epoch number, so a resumed
+	// run draws the same noise as an uninterrupted one. Data that implements
+	// EpochSetter is told the epoch number for the same reason.
+	Seed int64
+
+	// Epoch and Step count the completed epochs and optimizer steps.
+	Epoch int
+	Step  int
+	// Metrics holds the metrics of the last completed epoch. The Trainer
+	// records the mean batch loss as "loss"; callbacks add their own.
+	Metrics map[string]float64
+
+	stop bool
+}
+
+// Stop makes Fit return after the current epoch.
+func (t *Trainer) Stop() {
+	t.stop = true
+}
+
+// Fit trains until Epoch reaches epochs or a callback stops it. The model is
+// switched to training mode for every epoch and back to inference mode before
+// the callbacks run, so it is left in inference mode.
+func (t *Trainer) Fit(epochs int) error {
+	t.stop = false
+	params := t.Model.Params()
+	for t.Epoch < epochs && !t.stop {
+		t.Model.Seed(lab.DeriveSeed(t.Seed, "epoch "+strconv.Itoa(t.Epoch)))
+		t.Model.SetTraining(true)
+		if e, ok := t.Data.(EpochSetter); ok {
+			e.SetEpoch(t.Epoch)
+		}
+		t.Data.Reset()
+		var total float64
+		var batches int
+		for {
+			x, y := t.Data.Next()
+			if x == nil {
+				break
+			}
+			loss := t.step(x, y)
+			if t.Clipper != nil {
+				t.Clipper.Clip(params)
+			}
+			t.Optimizer.Step(params, t.rate())
+			ZeroGrad(params)
+			t.Step++
+			total += loss
+			batches++
+			for _, c := range t.Callbacks {
+				c.OnStep(t, loss)
+			}
+		}
+		t.Epoch++
+		t.Metrics = map[string]float64{"loss": total / math.Max(1, float64(batches))}
+		// Callbacks evaluate the model, so they see it in inference mode.
+		t.Model.SetTraining(false)
+		for _, c := range t.Callbacks {
+			if err := c.OnEpoch(t); err != nil {
+				return err
+			}
+		}
+		if o, ok := t.Schedule.(Observer); ok {
+			monitor := t.Monitor
+			if monitor == "" {
+				monitor = "loss"
+			}
+			if m, ok := t.Metrics[monitor]; ok {
+				o.Observe(m)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Trainer) step(x, y *lab.Matrix) float64 {
+	if t.StepFunc != nil {
+		return t.StepFunc(x, y)
+	}
+	if tg, ok := t.Loss.(Targeted); ok {
+		tg.SetTarget(y)
+	}
+	t.Loss.Reset()
+	loss := t.Loss.Loss(t.Model.Forward(x))
+	t.Model.Backward(t.Loss.Backward())
+	return loss
+}
+
+func (t *Trainer) rate() float64 {
+	if t.Schedule == nil {
+		return t.Rate
+	}
+	return t.Schedule.Rate(t.Step)
+}
+
+// Checkpoint captures the trainer's model, optimizer and schedule state.
+func (t *Trainer) Checkpoint() *Checkpoint {
+	c := &Checkpoint{
+		Model:          t.Model,
+		OptimizerState: t.Optimizer.State(),
+		Epoch:          t.Epoch,
+		Step:           t.Step,
+		Seed:           t.Seed,
+	}
+	if t.Schedule != nil {
+		c.ScheduleState = t.Schedule.State()
+	}
+	return c
+}
+
+// Resume restores the state saved by Checkpoint. The model of the trainer is
+// replaced by the checkpoint's.
+func (t *Trainer) Resume(c *Checkpoint) error {
+	if err := t.Optimizer.SetState(c.OptimizerState); err != nil {
+		return err
+	}
+	if t.Schedule != nil {
+		if err := t.Schedule.SetState(c.ScheduleState); err != nil {
+			return err
+		}
+	}
+	t.Model, t.Epoch, t.Step, t.Seed = c.Model, c.Epoch, c.Step, c.Seed
+	return nil
+}
+
+// Checkpointer saves a checkpoint every Every epochs (every epoch if Every is
+// 0) to Pattern formatted with the epoch number, e.g. "mnistE%d.ckpt".
+type Checkpointer struct {
+	Pattern string
+	Every   int
+	Float32 bool
+}
+
+func (c *Checkpointer) OnStep(*Trainer, float64) {}
+
+func (c *Checkpointer) OnEpoch(t *Trainer) error {
+	if c.Every > 1 && t.Epoch%c.Every != 0 {
+		return nil
+	}
+	ckpt := t.Checkpoint()
+	ckpt.Float32 = c.Float32
+	return ckpt.Save(fmt.Sprintf(c.Pattern, t.Epoch))
+}
+
+// Evaluator computes a metric on the model at the end of every epoch and
+// records it in Trainer.Metrics under Name.
+type Evaluator struct {
+	Name string
+	Eval func(model *Network) float64
+}
+
+func (e *Evaluator) OnStep(*Trainer, float64) {}
+
+func (e *Evaluator) OnEpoch(t *Trainer) error {
+	t.Metrics[e.Name] = e.Eval(t.Model)
+	return nil
+}
+
+// EarlyStopping stops training once Metric has not improved for Patience
+// epochs. Lower is better unless Maximize is set.
+type EarlyStopping struct {
+	Metric   string
+	Patience int
+	Maximize bool
+
+	best float64
+	bad  int
+	seen bool
+}
+
+func (e *EarlyStopping) OnStep(*Trainer, float64) {}
+
+func (e *EarlyStopping) OnEpoch(t *Trainer) error {
+	m, ok := t.Metrics[e.Metric]
+	if !ok {
+		return fmt.Errorf("nn: early stopping metric %q was not recorded", e.Metric)
+	}
+	if e.Maximize {
+		m = -m
+	}
+	if !e.seen || m < e.best {
+		e.best, e.seen, e.bad = m, true, 0
+		return nil
+	}
+	e.bad++
+	if e.bad >= e.Patience {
+		t.Stop()
+	}
+	return nil
+}
+
+// Logger writes the batch loss every Every steps (never if Every is 0) and
+// all metrics at the end of every epoch.
+type Logger struct {
+	W     io.Writer
+	Every int
+}
+
+func (l *Logger) OnStep(t *Trainer, loss float64) {
+	if l.Every > 0 && t.Step%l.Every == 0 {
+		fmt.Fprintf(l.W, "epoch %d step %d loss %g\n", t.Epoch+1, t.Step, loss)
+	}
+}
+
+func (l *Logger) OnEpoch(t *Trainer) error {
+	names := make([]string, 0, len(t.Metrics))
+	for name := range t.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %g", name, t.Metrics[name])
+	}
+	_, err := fmt.Fprintf(l.W, "Epoch %d %s\n", t.Epoch, strings.Join(parts, " "))
+	return err
+}
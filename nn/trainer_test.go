@@ -0,0 +1,128 @@
+package nn
+
+import (
+	"github.com/wizgrao/ml/lab"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sliceBatches serves fixed batches in order and records the epochs it is
+// set to.
+type sliceBatches struct {
+	x, y   []*lab.Matrix
+	i      int
+	epochs []int
+}
+
+func (s *sliceBatches) SetEpoch(epoch int) {
+	s.epochs = append(s.epochs, epoch)
+}
+
+func (s *sliceBatches) Next() (*lab.Matrix, *lab.Matrix) {
+	if s.i >= len(s.x) {
+		return nil, nil
+	}
+	s.i++
+	return s.x[s.i-1], s.y[s.i-1]
+}
+
+func (s *sliceBatches) Reset() {
+	s.i = 0
+}
+
+func TestTrainer(t *testing.T) {
+	// Two classes separated by the line x0 = x1.
+	data := &sliceBatches{}
+	for b := 0; b < 10; b++ {
+		x := lab.Gaussian(2, 16)
+		y := lab.NewMatrix(1, 16)
+		for j := 0; j < 16; j++ {
+			if x.Access(0, j) > x.Access(1, j) {
+				y.X[j] = 1
+			}
+		}
+		data.x = append(data.x, x)
+		data.y = append(data.y, y)
+	}
+	accuracy := func(model *Network) float64 {
+		var correct, total int
+		for b := range data.x {
+			out := model.Forward(data.x[b])
+			for j, c := range data.y[b].X {
+				guess := 0.0
+				if out.Access(1, j) > out.Access(0, j) {
+					guess = 1
+				}
+				if guess == c {
+					correct++
+				}
+				total++
+			}
+		}
+		return float64(correct) / float64(total)
+	}
+
+	dir := t.TempDir()
+	var steps int
+	trainer := &Trainer{
+		Model:     &Network{Layers: []Layer{NewFCLayer(2, 8), &TanhActivation{}, NewFCLayer(8, 2)}},
+		Loss:      NewSoftMaxCrossEntropy(2),
+		Data:      data,
+		Optimizer: NewAdam(),
+		Schedule:  &Constant{R: .05},
+		Callbacks: []Callback{
+			&Evaluator{Name: "accuracy", Eval: accuracy},
+			&EarlyStopping{Metric: "accuracy", Maximize: true, Patience: 5},
+			&Checkpointer{Pattern: filepath.Join(dir, "e%d.ckpt"), Every: 2},
+			stepCounter{&steps},
+		},
+	}
+	if err := trainer.Fit(100); err != nil {
+		t.Fatal(err)
+	}
+	if trainer.Step != steps || steps != 10*trainer.Epoch {
+		t.Errorf("trainer counted %d steps over %d epochs, callbacks saw %d", trainer.Step, trainer.Epoch, steps)
+	}
+	if acc := trainer.Metrics["accuracy"]; acc < .9 {
+		t.Errorf("accuracy %v after %d epochs, want at least .9", acc, trainer.Epoch)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "e3.ckpt")); err == nil {
+		t.Errorf("checkpoint written after epoch 3 with Every: 2")
+	}
+
+	// Early stopping can't fire before epoch 6, so epoch 4 was checkpointed.
+	ckpt, err := LoadCheckpoint(filepath.Join(dir, "e4.ckpt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := &Trainer{Optimizer: NewAdam(), Schedule: &Constant{R: .05}}
+	if err := resumed.Resume(ckpt); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Epoch != 4 || resumed.Step != 40 {
+		t.Errorf("resumed at epoch %d step %d, want 4 40", resumed.Epoch, resumed.Step)
+	}
+	// The data picks up at the epoch the checkpoint was taken at.
+	more := &sliceBatches{x: data.x, y: data.y}
+	resumed.Loss, resumed.Data = NewSoftMaxCrossEntropy(2), more
+	if err := resumed.Fit(6); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.epochs) != trainer.Epoch || data.epochs[3] != 3 || !reflect.DeepEqual(more.epochs, []int{4, 5}) {
+		t.Errorf("data set to epochs %v, then %v after resuming at 4", data.epochs, more.epochs)
+	}
+}
+
+type stepCounter struct {
+	n *int
+}
+
+func (s stepCounter) OnStep(*Trainer, float64) {
+	*s.n++
+}
+
+func (s stepCounter) OnEpoch(*Trainer) error {
+	return nil
+}